@@ -2,11 +2,15 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/retryhttp"
@@ -16,18 +20,57 @@ type BitriseClient struct {
 	logger     log.Logger
 	httpClient *http.Client
 	url        string
+	buildSlug  string
 	authToken  string
+	options    ClientOptions
+}
+
+// ClientOptions configures the BitriseClient, zero limits mean no limit and a zero RetryMax disables retries.
+type ClientOptions struct {
+	// MaxBodySize is the maximum size of a request body in bytes, larger payloads are split into multiple requests.
+	MaxBodySize int
+	// MaxBatchSize is the maximum number of env vars shared in a single request.
+	MaxBatchSize int
+	// RetryMax is the maximum number of retries of a failed request.
+	RetryMax int
+	// RetryWaitMin and RetryWaitMax bound the exponential backoff between retries.
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+	// MaxRetryWait is the maximum total time spent waiting between retries. Waits asked by the server with the
+	// Retry-After or rate limit headers are not shortened, the request is given up instead.
+	MaxRetryWait time.Duration
+}
+
+// DefaultClientOptions are the options of NewBitriseClient, the retries match the defaults of the retrying client.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		RetryMax:     4,
+		RetryWaitMin: time.Second,
+		RetryWaitMax: 30 * time.Second,
+	}
 }
 
 func NewBitriseClient(appURL, buildSLUG, authToken string, logger log.Logger) BitriseClient {
+	return NewBitriseClientWithOptions(appURL, buildSLUG, authToken, DefaultClientOptions(), logger)
+}
+
+func NewBitriseClientWithOptions(appURL, buildSLUG, authToken string, options ClientOptions, logger log.Logger) BitriseClient {
 	httpClient := retryhttp.NewClient(logger)
+	httpClient.RetryMax = options.RetryMax
+	httpClient.RetryWaitMin = options.RetryWaitMin
+	httpClient.RetryWaitMax = options.RetryWaitMax
+	retryPolicy := newRetryPolicy(logger, options.MaxRetryWait)
+	httpClient.CheckRetry = retryPolicy.checkRetry
+	httpClient.Backoff = retryPolicy.backoff
 	url := fmt.Sprintf("%s/pipeline/workflow_builds/%s/env_vars", appURL, buildSLUG)
 
 	return BitriseClient{
 		logger:     logger,
 		httpClient: httpClient.StandardClient(),
 		url:        url,
+		buildSlug:  buildSLUG,
 		authToken:  authToken,
+		options:    options,
 	}
 }
 
@@ -41,25 +84,195 @@ type ShareEnvVarsRequest struct {
 	SharedEnvs []SharedEnvVar `json:"shared_envs"`
 }
 
-func (c BitriseClient) ShareEnvVars(envVars []SharedEnvVar) error {
+// RequestBodySize returns the size of the request body sharing the given env vars in bytes.
+func RequestBodySize(envVars []SharedEnvVar) (int, error) {
+	body, err := json.Marshal(ShareEnvVarsRequest{SharedEnvs: envVars})
+	if err != nil {
+		return 0, err
+	}
+	return len(body), nil
+}
+
+// BatchError is returned when sharing a batch of env vars fails after the previous batches were accepted.
+type BatchError struct {
+	Batch      int
+	BatchCount int
+	Keys       []string
+	SharedKeys []string
+	Err        error
+}
+
+func (e *BatchError) Error() string {
+	msg := fmt.Sprintf("batch %d/%d (%s) failed: %s", e.Batch, e.BatchCount, strings.Join(e.Keys, ", "), e.Err)
+	if len(e.SharedKeys) == 0 {
+		return msg + ", no env vars were shared"
+	}
+	return msg + fmt.Sprintf(", env vars shared by the previous batches: %s", strings.Join(e.SharedKeys, ", "))
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ShareEnvVars shares the env vars in as many requests as the MaxBodySize and MaxBatchSize options require, and
+// returns the status code of the last response.
+func (c BitriseClient) ShareEnvVars(ctx context.Context, envVars []SharedEnvVar) (int, error) {
+	batches, err := SplitIntoBatches(envVars, c.options.MaxBodySize, c.options.MaxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(batches) == 1 {
+		return c.shareEnvVars(ctx, batches[0])
+	}
+
+	var statusCode int
+	var sharedKeys []string
+	for i, batch := range batches {
+		keys := keysOf(batch)
+		c.logger.Printf("Sharing batch %d/%d: %s", i+1, len(batches), strings.Join(keys, ", "))
+
+		if statusCode, err = c.shareEnvVars(ctx, batch); err != nil {
+			return statusCode, &BatchError{
+				Batch:      i + 1,
+				BatchCount: len(batches),
+				Keys:       keys,
+				SharedKeys: sharedKeys,
+				Err:        err,
+			}
+		}
+		sharedKeys = append(sharedKeys, keys...)
+	}
+
+	return statusCode, nil
+}
+
+// SplitIntoBatches splits the env vars into batches, so that the request body of a batch is not larger than
+// maxBodySize bytes and a batch has at most maxBatchSize env vars. Zero limits are ignored.
+func SplitIntoBatches(envVars []SharedEnvVar, maxBodySize, maxBatchSize int) ([][]SharedEnvVar, error) {
+	emptyBodySize, err := RequestBodySize([]SharedEnvVar{})
+	if err != nil {
+		return nil, err
+	}
+
+	batches := [][]SharedEnvVar{{}}
+	bodySize := emptyBodySize
+	for _, envVar := range envVars {
+		entry, err := json.Marshal(envVar)
+		if err != nil {
+			return nil, err
+		}
+		if maxBodySize > 0 && emptyBodySize+len(entry) > maxBodySize {
+			return nil, fmt.Errorf("env var %s does not fit into a request: %d bytes, max request body size is %d bytes", envVar.Key, emptyBodySize+len(entry), maxBodySize)
+		}
+
+		batch := batches[len(batches)-1]
+		entrySize := len(entry)
+		if len(batch) > 0 {
+			// separator comma
+			entrySize++
+		}
+
+		if len(batch) > 0 && ((maxBodySize > 0 && bodySize+entrySize > maxBodySize) || (maxBatchSize > 0 && len(batch) >= maxBatchSize)) {
+			batches = append(batches, []SharedEnvVar{})
+			bodySize = emptyBodySize
+			entrySize = len(entry)
+		}
+
+		batches[len(batches)-1] = append(batches[len(batches)-1], envVar)
+		bodySize += entrySize
+	}
+
+	return batches, nil
+}
+
+func keysOf(envVars []SharedEnvVar) []string {
+	var keys []string
+	for _, envVar := range envVars {
+		keys = append(keys, envVar.Key)
+	}
+	return keys
+}
+
+func (c BitriseClient) shareEnvVars(ctx context.Context, envVars []SharedEnvVar) (int, error) {
 	shareEnvVarsReq := ShareEnvVarsRequest{SharedEnvs: envVars}
 
 	body, err := json.Marshal(shareEnvVarsReq)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	// the retries of the request and the re-runs of the step share the same payload, so the server can apply it once
+	key := idempotencyKey(c.buildSlug, body)
+	req.Header.Set("Idempotency-Key", key)
+	c.logger.Printf("Idempotency key: %s", key)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkEnvVarShareResponse(resp); err != nil {
+		return resp.StatusCode, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+type ListSharedEnvVarsResponse struct {
+	SharedEnvs []SharedEnvVar `json:"shared_envs"`
+}
+
+// ListSharedEnvVars returns the env vars shared so far by the workflows of the pipeline.
+func (c BitriseClient) ListSharedEnvVars(ctx context.Context) ([]SharedEnvVar, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkEnvVarShareResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var listResp ListSharedEnvVarsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode shared env vars: %w", err)
+	}
+
+	return listResp.SharedEnvs, nil
+}
+
+type DeleteSharedEnvVarsRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// DeleteSharedEnvVars removes the given keys from the env vars shared by the workflows of the pipeline.
+func (c BitriseClient) DeleteSharedEnvVars(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(DeleteSharedEnvVarsRequest{Keys: keys})
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewBuffer(body))
+	req, err := c.newRequest(ctx, http.MethodDelete, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("content-type", "application/json; charset=UTF-8")
-	req.Header.Set("X-HTTP_BUILD_API_TOKEN", c.authToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if err := checkEnvVarShareResponse(resp); err != nil {
 		return err
@@ -68,27 +281,31 @@ func (c BitriseClient) ShareEnvVars(envVars []SharedEnvVar) error {
 	return nil
 }
 
-func checkEnvVarShareResponse(resp *http.Response) error {
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return nil
-	}
-	respErr := fmt.Errorf("request to %s failed: status code should be 2xx (%d)", resp.Request.URL, resp.StatusCode)
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return respErr
-	}
-	var respBodyJSON map[string]string
-	err = json.Unmarshal(respBody, &respBodyJSON)
+// idempotencyKey identifies a request body sent by a build.
+func idempotencyKey(buildSlug string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(buildSlug))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (c BitriseClient) newRequest(ctx context.Context, method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url, body)
 	if err != nil {
-		respErr = errors.New(respErr.Error() + fmt.Sprintf(", response body: %s", string(respBody)))
-		return respErr
+		return nil, err
 	}
-	clientRespErrMsg, isSet := respBodyJSON["error_msg"]
-	if !isSet || respBodyJSON["error_msg"] == "" {
-		respErr = errors.New(respErr.Error() + fmt.Sprintf(", response body: %s", respBodyJSON))
-		return respErr
+	if body != nil {
+		req.Header.Set("content-type", "application/json; charset=UTF-8")
 	}
-	respErr = errors.New(respErr.Error() + fmt.Sprintf(", message: %s", clientRespErrMsg))
+	req.Header.Set("X-HTTP_BUILD_API_TOKEN", c.authToken)
 
-	return respErr
+	return req, nil
+}
+
+func checkEnvVarShareResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newError(resp)
 }
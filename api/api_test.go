@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/require"
@@ -70,8 +73,9 @@ func TestBitriseClient_ShareEnvVars_SuccessfulRequest(t *testing.T) {
 	defer server.Close()
 
 	c := NewBitriseClient(server.URL, buildSlug, apiToken, log.NewLogger())
-	err := c.ShareEnvVars(envVars)
+	statusCode, err := c.ShareEnvVars(context.Background(), envVars)
 	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, statusCode)
 	require.Equal(t, true, serverCalled)
 }
 
@@ -94,8 +98,245 @@ func TestBitriseClient_ShareEnvVars_FailingRequest(t *testing.T) {
 	defer server.Close()
 
 	c := NewBitriseClient(server.URL, buildSlug, apiToken, log.NewLogger())
-	err := c.ShareEnvVars(envVars)
+	_, err := c.ShareEnvVars(context.Background(), envVars)
 	require.Error(t, err)
 	require.Equal(t, fmt.Sprintf("request to %s/pipeline/workflow_builds/slug/env_vars failed: status code should be 2xx (400), message: some error", server.URL), err.Error())
 	require.Equal(t, true, serverCalled)
 }
+
+func TestRequestBodySize(t *testing.T) {
+	envVars := []SharedEnvVar{{Key: "KEY", Value: "value"}}
+
+	size, err := RequestBodySize(envVars)
+	require.NoError(t, err)
+	require.Equal(t, len(`{"shared_envs":[{"key":"KEY","value":"value","is_sensitive":false}]}`), size)
+}
+
+func TestSplitIntoBatches(t *testing.T) {
+	envVar1 := SharedEnvVar{Key: "KEY_1", Value: "value"}
+	envVar2 := SharedEnvVar{Key: "KEY_2", Value: "value"}
+	envVar3 := SharedEnvVar{Key: "KEY_3", Value: "value"}
+	envVars := []SharedEnvVar{envVar1, envVar2, envVar3}
+
+	twoEntriesBodySize, err := RequestBodySize([]SharedEnvVar{envVar1, envVar2})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		envVars      []SharedEnvVar
+		maxBodySize  int
+		maxBatchSize int
+		want         [][]SharedEnvVar
+		wantErr      string
+	}{
+		{
+			name:    "No limits",
+			envVars: envVars,
+			want:    [][]SharedEnvVar{{envVar1, envVar2, envVar3}},
+		},
+		{
+			name:    "No env vars",
+			envVars: nil,
+			want:    [][]SharedEnvVar{{}},
+		},
+		{
+			name:         "Max batch size",
+			envVars:      envVars,
+			maxBatchSize: 2,
+			want:         [][]SharedEnvVar{{envVar1, envVar2}, {envVar3}},
+		},
+		{
+			name:        "Max body size fits two entries",
+			envVars:     envVars,
+			maxBodySize: twoEntriesBodySize,
+			want:        [][]SharedEnvVar{{envVar1, envVar2}, {envVar3}},
+		},
+		{
+			name:        "Max body size fits one entry",
+			envVars:     envVars,
+			maxBodySize: twoEntriesBodySize - 1,
+			want:        [][]SharedEnvVar{{envVar1}, {envVar2}, {envVar3}},
+		},
+		{
+			name:        "Entry larger than the max body size",
+			envVars:     []SharedEnvVar{envVar1, {Key: "LARGE", Value: strings.Repeat("a", 200)}},
+			maxBodySize: twoEntriesBodySize - 1,
+			wantErr:     "env var LARGE does not fit into a request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitIntoBatches(tt.envVars, tt.maxBodySize, tt.maxBatchSize)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+			for _, batch := range got {
+				size, err := RequestBodySize(batch)
+				require.NoError(t, err)
+				if tt.maxBodySize > 0 {
+					require.LessOrEqual(t, size, tt.maxBodySize)
+				}
+			}
+		})
+	}
+}
+
+func TestBitriseClient_ShareEnvVars_Batches(t *testing.T) {
+	envVars := []SharedEnvVar{
+		{Key: "KEY_1", Value: "value"},
+		{Key: "KEY_2", Value: "value"},
+		{Key: "KEY_3", Value: "value"},
+	}
+
+	var requestedKeys [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body ShareEnvVarsRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+
+		var keys []string
+		for _, envVar := range body.SharedEnvs {
+			keys = append(keys, envVar.Key)
+		}
+		requestedKeys = append(requestedKeys, keys)
+
+		if len(requestedKeys) == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error_msg":"some error"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := NewBitriseClientWithOptions(server.URL, "slug", "token", ClientOptions{MaxBatchSize: 1}, log.NewLogger())
+	_, err := c.ShareEnvVars(context.Background(), envVars)
+	require.EqualError(t, err, fmt.Sprintf("batch 2/3 (KEY_2) failed: request to %s/pipeline/workflow_builds/slug/env_vars failed: status code should be 2xx (400), message: some error, env vars shared by the previous batches: KEY_1", server.URL))
+
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Equal(t, []string{"KEY_1"}, batchErr.SharedKeys)
+	require.Equal(t, [][]string{{"KEY_1"}, {"KEY_2"}}, requestedKeys)
+}
+
+func TestBitriseClient_ListSharedEnvVars(t *testing.T) {
+	serverCalled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverCalled = true
+
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/pipeline/workflow_builds/slug/env_vars", r.URL.Path)
+		require.Equal(t, "token", r.Header.Get("X-HTTP_BUILD_API_TOKEN"))
+
+		_, _ = w.Write([]byte(`{"shared_envs":[{"key":"KEY","value":"value","is_sensitive":false},{"key":"SECRET_KEY","value":"secret value","is_sensitive":true}]}`))
+	}))
+	defer server.Close()
+
+	c := NewBitriseClient(server.URL, "slug", "token", log.NewLogger())
+	envVars, err := c.ListSharedEnvVars(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []SharedEnvVar{
+		{Key: "KEY", Value: "value", Sensitive: false},
+		{Key: "SECRET_KEY", Value: "secret value", Sensitive: true},
+	}, envVars)
+	require.Equal(t, true, serverCalled)
+}
+
+func TestBitriseClient_ListSharedEnvVars_FailingRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error_msg":"not found"}`))
+	}))
+	defer server.Close()
+
+	c := NewBitriseClient(server.URL, "slug", "token", log.NewLogger())
+	_, err := c.ListSharedEnvVars(context.Background())
+	require.EqualError(t, err, fmt.Sprintf("request to %s/pipeline/workflow_builds/slug/env_vars failed: status code should be 2xx (404), message: not found", server.URL))
+}
+
+func TestBitriseClient_DeleteSharedEnvVars(t *testing.T) {
+	serverCalled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverCalled = true
+
+		require.Equal(t, http.MethodDelete, r.Method)
+		require.Equal(t, "/pipeline/workflow_builds/slug/env_vars", r.URL.Path)
+		require.Equal(t, "token", r.Header.Get("X-HTTP_BUILD_API_TOKEN"))
+		require.Equal(t, "application/json; charset=UTF-8", r.Header.Get("content-type"))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, `{"keys":["OLD_KEY","OTHER_KEY"]}`, string(body))
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := NewBitriseClient(server.URL, "slug", "token", log.NewLogger())
+	require.NoError(t, c.DeleteSharedEnvVars(context.Background(), []string{"OLD_KEY", "OTHER_KEY"}))
+	require.Equal(t, true, serverCalled)
+}
+
+func TestBitriseClient_DeleteSharedEnvVars_FailingRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"error_msg":"OLD_KEY is not shared"}`))
+	}))
+	defer server.Close()
+
+	c := NewBitriseClient(server.URL, "slug", "token", log.NewLogger())
+	err := c.DeleteSharedEnvVars(context.Background(), []string{"OLD_KEY"})
+	require.EqualError(t, err, fmt.Sprintf("request to %s/pipeline/workflow_builds/slug/env_vars failed: status code should be 2xx (422), message: OLD_KEY is not shared", server.URL))
+}
+
+func TestBitriseClient_ShareEnvVars_Retries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	options := ClientOptions{RetryMax: 2, RetryWaitMin: time.Millisecond, RetryWaitMax: time.Millisecond}
+	c := NewBitriseClientWithOptions(server.URL, "slug", "token", options, log.NewLogger())
+	_, err := c.ShareEnvVars(context.Background(), []SharedEnvVar{{Key: "KEY", Value: "value"}})
+	require.Error(t, err)
+	require.Equal(t, 3, requests)
+}
+
+func TestBitriseClient_ShareEnvVars_IdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	envVars := []SharedEnvVar{{Key: "KEY", Value: "value"}}
+	body, err := json.Marshal(ShareEnvVarsRequest{SharedEnvs: envVars})
+	require.NoError(t, err)
+
+	options := ClientOptions{RetryMax: 1, RetryWaitMin: time.Millisecond, RetryWaitMax: time.Millisecond}
+	c := NewBitriseClientWithOptions(server.URL, "slug", "token", options, log.NewLogger())
+	_, err = c.ShareEnvVars(context.Background(), envVars)
+	require.NoError(t, err)
+	_, err = c.ShareEnvVars(context.Background(), envVars)
+	require.NoError(t, err)
+
+	wantKey := idempotencyKey("slug", body)
+	require.Equal(t, []string{wantKey, wantKey, wantKey}, keys)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	key := idempotencyKey("slug", []byte(`{"shared_envs":[]}`))
+	require.Len(t, key, 64)
+	require.Equal(t, key, idempotencyKey("slug", []byte(`{"shared_envs":[]}`)))
+	require.NotEqual(t, key, idempotencyKey("other-slug", []byte(`{"shared_envs":[]}`)))
+	require.NotEqual(t, key, idempotencyKey("slug", []byte(`{"shared_envs":[{"key":"KEY","value":"","is_sensitive":false}]}`)))
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Error is returned when the env_vars endpoint responds with a non-2xx status code.
+type Error struct {
+	URL        string
+	StatusCode int
+	// Message is the error_msg of the response body, if any.
+	Message string
+	// Fields are the validation errors of the response body by field, if any.
+	Fields map[string][]string
+	// Body is the raw response body.
+	Body string
+	// Retryable is true if the request may succeed when sent again later.
+	Retryable bool
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("request to %s failed: status code should be 2xx (%d)", e.URL, e.StatusCode)
+
+	if e.Body == "" && e.Message == "" {
+		return msg
+	}
+
+	var bodyJSON map[string]string
+	if err := json.Unmarshal([]byte(e.Body), &bodyJSON); err != nil {
+		if e.Message == "" {
+			return msg + fmt.Sprintf(", response body: %s", e.Body)
+		}
+	} else if e.Message == "" {
+		return msg + fmt.Sprintf(", response body: %s", bodyJSON)
+	}
+
+	msg += fmt.Sprintf(", message: %s", e.Message)
+	if len(e.Fields) > 0 {
+		msg += fmt.Sprintf(" (%s)", e.fieldErrors())
+	}
+	return msg
+}
+
+func (e *Error) fieldErrors() string {
+	var fields []string
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var fieldErrors []string
+	for _, field := range fields {
+		fieldErrors = append(fieldErrors, fmt.Sprintf("%s: %s", field, strings.Join(e.Fields[field], ", ")))
+	}
+	return strings.Join(fieldErrors, "; ")
+}
+
+// isRetryableStatus reports whether a request failed with the status code may succeed later.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+type errorResponse struct {
+	ErrorMsg string                     `json:"error_msg"`
+	Errors   map[string]json.RawMessage `json:"errors"`
+}
+
+func newError(resp *http.Response) *Error {
+	apiErr := &Error{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Retryable:  isRetryableStatus(resp.StatusCode),
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+	apiErr.Body = string(body)
+
+	var errResp errorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		return apiErr
+	}
+	apiErr.Message = errResp.ErrorMsg
+	for field, rawErrors := range errResp.Errors {
+		// a field has a single error message or a list of them
+		var fieldErrors []string
+		if err := json.Unmarshal(rawErrors, &fieldErrors); err != nil {
+			var fieldError string
+			if err := json.Unmarshal(rawErrors, &fieldError); err != nil {
+				continue
+			}
+			fieldErrors = []string{fieldError}
+		}
+		if apiErr.Fields == nil {
+			apiErr.Fields = map[string][]string{}
+		}
+		apiErr.Fields[field] = fieldErrors
+	}
+
+	return apiErr
+}
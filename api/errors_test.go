@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitriseClient_ShareEnvVars_Error(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       Error
+		wantMsg    string
+	}{
+		{
+			name:       "Error message",
+			statusCode: http.StatusUnauthorized,
+			body:       `{"error_msg":"invalid token"}`,
+			want: Error{
+				StatusCode: http.StatusUnauthorized,
+				Message:    "invalid token",
+				Body:       `{"error_msg":"invalid token"}`,
+			},
+			wantMsg: "status code should be 2xx (401), message: invalid token",
+		},
+		{
+			name:       "Validation errors",
+			statusCode: http.StatusUnprocessableEntity,
+			body:       `{"error_msg":"invalid env vars","errors":{"shared_envs.0.key":["is reserved","is too long"],"shared_envs.1.value":"is too long"}}`,
+			want: Error{
+				StatusCode: http.StatusUnprocessableEntity,
+				Message:    "invalid env vars",
+				Fields: map[string][]string{
+					"shared_envs.0.key":   {"is reserved", "is too long"},
+					"shared_envs.1.value": {"is too long"},
+				},
+				Body: `{"error_msg":"invalid env vars","errors":{"shared_envs.0.key":["is reserved","is too long"],"shared_envs.1.value":"is too long"}}`,
+			},
+			wantMsg: "status code should be 2xx (422), message: invalid env vars (shared_envs.0.key: is reserved, is too long; shared_envs.1.value: is too long)",
+		},
+		{
+			name:       "JSON body without error message",
+			statusCode: http.StatusConflict,
+			body:       `{"status":"conflict"}`,
+			want: Error{
+				StatusCode: http.StatusConflict,
+				Body:       `{"status":"conflict"}`,
+			},
+			wantMsg: "status code should be 2xx (409), response body: map[status:conflict]",
+		},
+		{
+			name:       "Not a JSON body",
+			statusCode: http.StatusTooManyRequests,
+			body:       "slow down",
+			want: Error{
+				StatusCode: http.StatusTooManyRequests,
+				Body:       "slow down",
+				Retryable:  true,
+			},
+			wantMsg: "status code should be 2xx (429), response body: slow down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			// retryable responses are retried once without waiting
+			c := NewBitriseClientWithOptions(server.URL, "slug", "token", ClientOptions{RetryMax: 1, RetryWaitMin: 1, RetryWaitMax: 1}, log.NewLogger())
+			_, err := c.ShareEnvVars(context.Background(), []SharedEnvVar{{Key: "KEY", Value: "value"}})
+
+			var apiErr *Error
+			require.True(t, errors.As(err, &apiErr))
+			tt.want.URL = server.URL + "/pipeline/workflow_builds/slug/env_vars"
+			require.Equal(t, tt.want, *apiErr)
+			require.EqualError(t, err, fmt.Sprintf("request to %s failed: %s", tt.want.URL, tt.wantMsg))
+		})
+	}
+}
+
+func TestBatchError_Unwrap(t *testing.T) {
+	apiErr := &Error{StatusCode: http.StatusConflict}
+	err := fmt.Errorf("failed to share: %w", &BatchError{Batch: 2, BatchCount: 2, Err: apiErr})
+
+	var target *Error
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, apiErr, target)
+}
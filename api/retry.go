@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// unixTimestampThreshold tells apart rate limit reset headers holding a unix timestamp from the ones holding
+// the number of seconds until the reset.
+const unixTimestampThreshold = 1_000_000_000
+
+// retryPolicy waits as long as the server asks for with the Retry-After and rate limit headers, and gives up once
+// the total wait of the client would exceed maxWait.
+type retryPolicy struct {
+	logger  log.Logger
+	maxWait time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	waited time.Duration
+}
+
+func newRetryPolicy(logger log.Logger, maxWait time.Duration) *retryPolicy {
+	return &retryPolicy{
+		logger:  logger,
+		maxWait: maxWait,
+		now:     time.Now,
+	}
+}
+
+func (p *retryPolicy) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	shouldRetry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if !shouldRetry || p.maxWait <= 0 {
+		return shouldRetry, checkErr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	remaining := p.maxWait - p.waited
+	if wait, header, ok := p.serverWait(resp); ok && wait > remaining {
+		p.logger.Warnf("The server asked to wait %s (%s header), which exceeds the remaining retry wait of %s, giving up", wait, header, remaining)
+		return false, checkErr
+	}
+	if remaining <= 0 {
+		p.logger.Warnf("The total retry wait reached %s, giving up", p.maxWait)
+		return false, checkErr
+	}
+
+	return true, checkErr
+}
+
+func (p *retryPolicy) backoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wait, header, fromServer := p.serverWait(resp)
+	if !fromServer {
+		wait = retryablehttp.DefaultBackoff(min, max, attemptNum, nil)
+	}
+	if p.maxWait > 0 && p.waited+wait > p.maxWait {
+		wait = p.maxWait - p.waited
+	}
+	p.waited += wait
+
+	switch {
+	case fromServer:
+		p.logger.Warnf("Request was throttled (%d), waiting %s before retrying as asked by the %s header", resp.StatusCode, wait, header)
+	case resp != nil:
+		p.logger.Warnf("Request failed (%d), waiting %s before retrying", resp.StatusCode, wait)
+	default:
+		p.logger.Warnf("Request failed, waiting %s before retrying", wait)
+	}
+
+	return wait
+}
+
+// serverWait returns how long the server asked the client to wait before retrying and the header it asked with.
+func (p *retryPolicy) serverWait(resp *http.Response) (time.Duration, string, bool) {
+	if resp == nil {
+		return 0, "", false
+	}
+
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return nonNegative(time.Duration(seconds) * time.Second), "Retry-After", true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return nonNegative(date.Sub(p.now())), "Retry-After", true
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, "", false
+	}
+	for _, header := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		reset, err := strconv.ParseInt(resp.Header.Get(header), 10, 64)
+		if err != nil {
+			continue
+		}
+		if reset > unixTimestampThreshold {
+			return nonNegative(time.Unix(reset, 0).Sub(p.now())), header, true
+		}
+		return nonNegative(time.Duration(reset) * time.Second), header, true
+	}
+
+	return 0, "", false
+}
+
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_serverWait(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		statusCode int
+		headers    map[string]string
+		wantWait   time.Duration
+		wantHeader string
+		wantOK     bool
+	}{
+		{
+			name:       "Retry-After seconds",
+			statusCode: http.StatusTooManyRequests,
+			headers:    map[string]string{"Retry-After": "7"},
+			wantWait:   7 * time.Second,
+			wantHeader: "Retry-After",
+			wantOK:     true,
+		},
+		{
+			name:       "Retry-After date",
+			statusCode: http.StatusServiceUnavailable,
+			headers:    map[string]string{"Retry-After": "Tue, 02 Jan 2024 15:04:35 GMT"},
+			wantWait:   30 * time.Second,
+			wantHeader: "Retry-After",
+			wantOK:     true,
+		},
+		{
+			name:       "Retry-After date in the past",
+			statusCode: http.StatusTooManyRequests,
+			headers:    map[string]string{"Retry-After": "Tue, 02 Jan 2024 15:00:00 GMT"},
+			wantWait:   0,
+			wantHeader: "Retry-After",
+			wantOK:     true,
+		},
+		{
+			name:       "Rate limit reset timestamp",
+			statusCode: http.StatusTooManyRequests,
+			headers:    map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "1704207905"},
+			wantWait:   60 * time.Second,
+			wantHeader: "X-RateLimit-Reset",
+			wantOK:     true,
+		},
+		{
+			name:       "Rate limit reset seconds",
+			statusCode: http.StatusTooManyRequests,
+			headers:    map[string]string{"RateLimit-Reset": "12"},
+			wantWait:   12 * time.Second,
+			wantHeader: "RateLimit-Reset",
+			wantOK:     true,
+		},
+		{
+			name:       "Rate limit reset of a server error with remaining requests",
+			statusCode: http.StatusInternalServerError,
+			headers:    map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": "12"},
+		},
+		{
+			name:       "No headers",
+			statusCode: http.StatusTooManyRequests,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRetryPolicy(log.NewLogger(), 0)
+			p.now = func() time.Time { return now }
+
+			resp := &http.Response{StatusCode: tt.statusCode, Header: http.Header{}}
+			for key, value := range tt.headers {
+				resp.Header.Set(key, value)
+			}
+
+			wait, header, ok := p.serverWait(resp)
+			require.Equal(t, tt.wantWait, wait)
+			require.Equal(t, tt.wantHeader, header)
+			require.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestBitriseClient_ShareEnvVars_RetryAfter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the default minimum wait of 1s would be used without the Retry-After header
+	options := ClientOptions{RetryMax: 3, RetryWaitMin: time.Hour, RetryWaitMax: time.Hour}
+	c := NewBitriseClientWithOptions(server.URL, "slug", "token", options, log.NewLogger())
+	statusCode, err := c.ShareEnvVars(context.Background(), []SharedEnvVar{{Key: "KEY", Value: "value"}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, statusCode)
+	require.Equal(t, 3, requests)
+}
+
+func TestBitriseClient_ShareEnvVars_MaxRetryWait(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	options := ClientOptions{RetryMax: 3, MaxRetryWait: time.Minute}
+	c := NewBitriseClientWithOptions(server.URL, "slug", "token", options, log.NewLogger())
+	_, err := c.ShareEnvVars(context.Background(), []SharedEnvVar{{Key: "KEY", Value: "value"}})
+
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	require.Equal(t, 1, requests)
+}
+
+func TestBitriseClient_ShareEnvVars_NoRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	options := ClientOptions{RetryMax: 0, RetryWaitMin: 0, RetryWaitMax: 0}
+	c := NewBitriseClientWithOptions(server.URL, "slug", "token", options, log.NewLogger())
+	_, err := c.ShareEnvVars(context.Background(), []SharedEnvVar{{Key: "KEY", Value: "value"}})
+
+	var apiErr *Error
+	require.True(t, errors.As(err, &apiErr))
+	require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	require.Equal(t, 1, requests)
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := newRetryPolicy(log.NewLogger(), 5*time.Second)
+
+	require.Equal(t, 2*time.Second, p.backoff(time.Second, time.Minute, 1, nil))
+	require.Equal(t, 3*time.Second, p.backoff(time.Second, time.Minute, 2, nil))
+
+	shouldRetry, err := p.checkRetry(context.Background(), nil, errors.New("connection reset"))
+	require.NoError(t, err)
+	require.False(t, shouldRetry)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-io/go-steputils/v2/secretkeys"
+	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-utils/v2/env"
+	. "github.com/bitrise-io/go-utils/v2/exitcode"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/step"
+)
+
+const usage = `Usage: share-pipeline-variable <command> [flags]
+
+Without a command the Step runs with its inputs read from the environment.
+
+Commands:
+  share      Share the variables, --dry-run prints what would be shared without sharing it
+  list       List the variables shared so far
+  validate   Check the variables for mistakes, without calling the API
+  diff       Compare the variables with the ones shared so far
+
+Every command accepts the flags of the Step inputs, run <command> -h to list them.
+`
+
+type cliCommand struct {
+	mode step.Mode
+	diff bool
+	// dryRunFlag adds the --dry-run flag to the command.
+	dryRunFlag bool
+}
+
+var cliCommands = map[string]cliCommand{
+	"share":    {mode: step.ModeShare, dryRunFlag: true},
+	"list":     {mode: step.ModeList},
+	"validate": {mode: step.ModeValidate},
+	"diff":     {mode: step.ModeShare, diff: true},
+}
+
+type inputFlag struct {
+	input        string
+	defaultValue string
+	// envFallback is read after parsing the flags instead of being the default, so its value is not printed
+	// in the usage.
+	envFallback string
+	usage       string
+}
+
+// inputFlags mirror the inputs of the step.yml, except the mode, dry_run and export_to_workflow inputs. The mode is
+// selected by the command and dry_run is a boolean flag of the share command.
+var inputFlags = []inputFlag{
+	{input: "variables", usage: "newline separated variables to share, see the variables input"},
+	{input: "variables_file", usage: "path to a .env, JSON or YAML file with variables to share, - reads the variables from the stdin"},
+	{input: "variables_format", defaultValue: "auto", usage: "format of the variables: auto, lines, json or yaml"},
+	{input: "missing_variable_policy", defaultValue: "fail", usage: "fail, warn_and_skip or share_empty"},
+	{input: "reserved_keys", usage: "newline separated keys (or glob patterns) which can't be shared"},
+	{input: "duplicate_policy", defaultValue: "last_wins", usage: "error, first_wins or last_wins"},
+	{input: "secret_detection", defaultValue: "off", usage: "off, mark_sensitive or fail"},
+	{input: "conflict_policy", defaultValue: "overwrite", usage: "overwrite, skip or fail"},
+	{input: "max_request_body_size", defaultValue: "1048576", usage: "maximum size of a request body in bytes"},
+	{input: "max_batch_size", defaultValue: "100", usage: "maximum number of variables shared in a request"},
+	{input: "max_key_length", defaultValue: "256", usage: "maximum length of a key in bytes"},
+	{input: "max_value_length", defaultValue: "262144", usage: "maximum length of a value in bytes"},
+	{input: "max_variable_count", defaultValue: "1000", usage: "maximum number of shared variables"},
+	{input: "timeout", defaultValue: "300", usage: "maximum time of the API calls in seconds"},
+	{input: "retry_count", defaultValue: "4", usage: "maximum number of retries of a failed API call"},
+	{input: "retry_wait_min", defaultValue: "1", usage: "minimum wait before a retry in seconds"},
+	{input: "retry_wait_max", defaultValue: "30", usage: "maximum wait before a retry in seconds"},
+	{input: "max_retry_wait", defaultValue: "180", usage: "maximum total wait between the retries in seconds"},
+	{input: "deploy_dir", envFallback: "BITRISE_DEPLOY_DIR", usage: "directory of the share report, defaults to $BITRISE_DEPLOY_DIR"},
+	{input: "app_url", envFallback: "BITRISE_APP_URL", usage: "the app's URL on Bitrise.io, defaults to $BITRISE_APP_URL"},
+	{input: "build_slug", envFallback: "BITRISE_BUILD_SLUG", usage: "the build's slug on Bitrise.io, defaults to $BITRISE_BUILD_SLUG"},
+	{input: "build_api_token", envFallback: "BITRISE_BUILD_API_TOKEN", usage: "API token of the build, defaults to $BITRISE_BUILD_API_TOKEN"},
+}
+
+// inputRepository serves the step inputs from the command line flags and every other env var from the environment.
+type inputRepository struct {
+	env.Repository
+	inputs map[string]string
+}
+
+func (r inputRepository) Get(key string) string {
+	if value, ok := r.inputs[key]; ok {
+		return value
+	}
+	return r.Repository.Get(key)
+}
+
+// logExporter prints the outputs, as there is no env store to export them into outside of a build.
+type logExporter struct {
+	logger log.Logger
+}
+
+func (e logExporter) ExportOutput(key, value string) error {
+	e.logger.Printf("Output %s: %s", key, value)
+	return nil
+}
+
+func (e logExporter) ExportEnvVar(key, value string, sensitive bool) error {
+	if sensitive {
+		value = "[REDACTED]"
+	}
+	e.logger.Printf("Env var %s: %s", key, value)
+	return nil
+}
+
+func runCommand(logger log.Logger, args []string) ExitCode {
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return Success
+	}
+
+	command, ok := cliCommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n%s", name, usage)
+		return Failure
+	}
+
+	inputs, err := parseInputFlags(name, command.dryRunFlag, args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return Success
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return Failure
+	}
+	if err := readStdinVariables(inputs, os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return Failure
+	}
+	inputs["mode"] = string(command.mode)
+	inputs["export_to_workflow"] = "false"
+
+	envRepository := env.NewRepository()
+	inputParser := stepconf.NewInputParser(inputRepository{Repository: envRepository, inputs: inputs})
+	envVarSharer := step.NewEnvVarSharer(logger, inputParser, envRepository, secretkeys.NewManager(), logExporter{logger: logger})
+
+	config, err := envVarSharer.ProcessConfig()
+	if err != nil {
+		return processConfigFailure(logger, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if command.diff {
+		err = envVarSharer.Diff(ctx, *config)
+	} else {
+		err = envVarSharer.Run(ctx, *config)
+	}
+	return runFailure(logger, err)
+}
+
+func parseInputFlags(command string, dryRunFlag bool, args []string, getenv func(string) string) (map[string]string, error) {
+	flagSet := flag.NewFlagSet(command, flag.ContinueOnError)
+	values := map[string]*string{}
+	for _, inputFlag := range inputFlags {
+		values[inputFlag.input] = flagSet.String(flagName(inputFlag.input), inputFlag.defaultValue, inputFlag.usage)
+	}
+	dryRun := new(bool)
+	if dryRunFlag {
+		flagSet.BoolVar(dryRun, "dry-run", false, "print what would be shared without sharing it")
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+	if flagSet.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flagSet.Args(), " "))
+	}
+
+	setFlags := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	inputs := map[string]string{}
+	for _, inputFlag := range inputFlags {
+		value := *values[inputFlag.input]
+		if inputFlag.envFallback != "" && !setFlags[flagName(inputFlag.input)] {
+			value = getenv(inputFlag.envFallback)
+		}
+		inputs[inputFlag.input] = value
+	}
+	inputs["dry_run"] = fmt.Sprintf("%t", *dryRun)
+	return inputs, nil
+}
+
+func flagName(input string) string {
+	return strings.ReplaceAll(input, "_", "-")
+}
+
+// readStdinVariables reads the variables input from stdin when the variables file is -, for example to validate
+// the variables of a bitrise.yml in a pre-commit hook.
+func readStdinVariables(inputs map[string]string, stdin io.Reader) error {
+	if inputs["variables_file"] != "-" {
+		return nil
+	}
+	if inputs["variables"] != "" {
+		return errors.New("variables can't be set when the variables are read from the stdin")
+	}
+
+	content, err := io.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read variables from the stdin: %w", err)
+	}
+	inputs["variables"] = string(content)
+	inputs["variables_file"] = ""
+	return nil
+}
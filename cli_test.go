@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/bitrise-io/go-utils/v2/exitcode"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseInputFlags(t *testing.T) {
+	getenv := func(key string) string {
+		return map[string]string{
+			"BITRISE_BUILD_SLUG":      "env-slug",
+			"BITRISE_BUILD_API_TOKEN": "env-token",
+		}[key]
+	}
+
+	inputs, err := parseInputFlags("share", true, []string{"--variables", "BUILD_TYPE=debug", "--conflict-policy=fail", "--build-slug", "slug"}, getenv)
+	require.NoError(t, err)
+	require.Equal(t, "BUILD_TYPE=debug", inputs["variables"])
+	require.Equal(t, "fail", inputs["conflict_policy"])
+	require.Equal(t, "slug", inputs["build_slug"])
+	require.Equal(t, "env-token", inputs["build_api_token"])
+	require.Equal(t, "", inputs["app_url"])
+	require.Equal(t, "last_wins", inputs["duplicate_policy"])
+	require.Equal(t, "false", inputs["dry_run"])
+	require.Equal(t, len(inputFlags)+1, len(inputs))
+
+	for _, inputFlag := range inputFlags {
+		if inputFlag.envFallback != "" {
+			require.Empty(t, inputFlag.defaultValue, "%s: env var fallbacks are not printed as defaults", inputFlag.input)
+		}
+	}
+
+	_, err = parseInputFlags("share", true, []string{"--variables", "BUILD_TYPE=debug", "extra"}, getenv)
+	require.EqualError(t, err, "unexpected arguments: extra")
+
+	_, err = parseInputFlags("share", true, []string{"--unknown"}, getenv)
+	require.EqualError(t, err, "flag provided but not defined: -unknown")
+
+	inputs, err = parseInputFlags("share", true, []string{"--variables", "BUILD_TYPE=debug", "--dry-run"}, getenv)
+	require.NoError(t, err)
+	require.Equal(t, "true", inputs["dry_run"])
+
+	_, err = parseInputFlags("list", false, []string{"--dry-run"}, getenv)
+	require.EqualError(t, err, "flag provided but not defined: -dry-run")
+}
+
+func Test_inputRepository(t *testing.T) {
+	envRepository := new(mocks.Repository)
+	envRepository.On("Get", "BITRISE_SECRET_ENV_KEY_LIST").Return("API_TOKEN")
+
+	repository := inputRepository{Repository: envRepository, inputs: map[string]string{"variables": "BUILD_TYPE=debug", "app_url": ""}}
+	require.Equal(t, "BUILD_TYPE=debug", repository.Get("variables"))
+	require.Equal(t, "", repository.Get("app_url"))
+	require.Equal(t, "API_TOKEN", repository.Get("BITRISE_SECRET_ENV_KEY_LIST"))
+}
+
+func Test_readStdinVariables(t *testing.T) {
+	inputs := map[string]string{"variables": "", "variables_file": "-"}
+	require.NoError(t, readStdinVariables(inputs, strings.NewReader("BUILD_TYPE=debug\n")))
+	require.Equal(t, map[string]string{"variables": "BUILD_TYPE=debug\n", "variables_file": ""}, inputs)
+
+	inputs = map[string]string{"variables": "", "variables_file": "shared.env"}
+	require.NoError(t, readStdinVariables(inputs, strings.NewReader("BUILD_TYPE=debug\n")))
+	require.Equal(t, "shared.env", inputs["variables_file"])
+
+	inputs = map[string]string{"variables": "BUILD_TYPE=debug", "variables_file": "-"}
+	require.EqualError(t, readStdinVariables(inputs, strings.NewReader("")), "variables can't be set when the variables are read from the stdin")
+}
+
+func Test_apiFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		retryable  bool
+		want       ExitCode
+		wantAdvice string
+	}{
+		{name: "Unauthorized", statusCode: http.StatusUnauthorized, want: AuthenticationFailure, wantAdvice: "build API token was rejected"},
+		{name: "Not found", statusCode: http.StatusNotFound, want: NotFoundFailure, wantAdvice: "build was not found"},
+		{name: "Conflict", statusCode: http.StatusConflict, want: ConflictFailure, wantAdvice: "changed by another workflow"},
+		{name: "Unprocessable entity", statusCode: http.StatusUnprocessableEntity, want: ValidationFailure, wantAdvice: "rejected by the API"},
+		{name: "Too many requests", statusCode: http.StatusTooManyRequests, retryable: true, want: UnavailableFailure, wantAdvice: "unavailable or throttled"},
+		{name: "Service unavailable", statusCode: http.StatusServiceUnavailable, retryable: true, want: UnavailableFailure, wantAdvice: "unavailable or throttled"},
+	}
+	for _, tt := range tests {
+		apiErr := &api.Error{URL: "https://app.bitrise.io", StatusCode: tt.statusCode, Retryable: tt.retryable}
+		errs := map[string]error{
+			"API error":   fmt.Errorf("failed to share env vars: %w", apiErr),
+			"Batch error": &api.BatchError{Batch: 2, BatchCount: 2, Keys: []string{"KEY"}, SharedKeys: []string{"SHARED_KEY"}, Err: apiErr},
+		}
+		for kind, err := range errs {
+			t.Run(tt.name+"/"+kind, func(t *testing.T) {
+				exitCode, advice := apiFailure(err)
+				require.Equal(t, tt.want, exitCode)
+				require.Contains(t, advice, tt.wantAdvice)
+			})
+		}
+	}
+
+	t.Run("Not an API error", func(t *testing.T) {
+		exitCode, advice := apiFailure(errors.New("failed to read variables file"))
+		require.Equal(t, Failure, exitCode)
+		require.Equal(t, "", advice)
+	})
+}
@@ -5,15 +5,15 @@ go 1.19
 require (
 	github.com/bitrise-io/go-steputils/v2 v2.0.0-alpha.19
 	github.com/bitrise-io/go-utils/v2 v2.0.0-alpha.16
+	github.com/hashicorp/go-retryablehttp v0.7.1
 	github.com/stretchr/testify v1.8.2
 	golang.org/x/exp v0.0.0-20230807204917-050eac23e9de
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
-	github.com/hashicorp/go-retryablehttp v0.7.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,41 +1,101 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/bitrise-io/go-steputils/v2/secretkeys"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
+	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/errorutil"
 	. "github.com/bitrise-io/go-utils/v2/exitcode"
 	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
 	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/step"
 )
 
+// Exit codes of the failed API calls, other failures exit with Failure.
+const (
+	AuthenticationFailure ExitCode = 3
+	NotFoundFailure       ExitCode = 4
+	ConflictFailure       ExitCode = 5
+	ValidationFailure     ExitCode = 6
+	UnavailableFailure    ExitCode = 7
+)
+
 func main() {
-	exitCode := run()
+	exitCode := run(os.Args[1:])
 	os.Exit(int(exitCode))
 }
 
-func run() ExitCode {
+func run(args []string) ExitCode {
 	logger := log.NewLogger()
+	if len(args) > 0 {
+		return runCommand(logger, args)
+	}
+
 	envVarSharer := createEnvVarSharer(logger)
 
 	config, err := envVarSharer.ProcessConfig()
 	if err != nil {
-		logger.Println()
-		logger.Errorf(errorutil.FormattedError(fmt.Errorf("Failed to process Step inputs: %w", err)))
-		return Failure
+		return processConfigFailure(logger, err)
+	}
+
+	// the build is aborted with SIGTERM, in-flight API calls are cancelled instead of waiting for their retries
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	return runFailure(logger, envVarSharer.Run(ctx, *config))
+}
+
+func processConfigFailure(logger log.Logger, err error) ExitCode {
+	logger.Println()
+	logger.Errorf(errorutil.FormattedError(fmt.Errorf("Failed to process Step inputs: %w", err)))
+	return Failure
+}
+
+func runFailure(logger log.Logger, err error) ExitCode {
+	if err == nil {
+		return Success
+	}
+
+	logger.Println()
+	logger.Errorf(errorutil.FormattedError(fmt.Errorf("Failed to execute Step: %w", err)))
+
+	exitCode, advice := apiFailure(err)
+	if advice != "" {
+		logger.Warnf(advice)
 	}
+	return exitCode
+}
 
-	if err := envVarSharer.Run(*config); err != nil {
-		logger.Println()
-		logger.Errorf(errorutil.FormattedError(fmt.Errorf("Failed to execute Step: %w", err)))
-		return Failure
+// apiFailure maps a failed API call to an exit code and an advice on how to fix it.
+func apiFailure(err error) (ExitCode, string) {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) {
+		return Failure, ""
 	}
 
-	return Success
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		return AuthenticationFailure, "The build API token was rejected, check that the build_api_token input is set to $BITRISE_BUILD_API_TOKEN."
+	case apiErr.StatusCode == http.StatusNotFound:
+		return NotFoundFailure, "The build was not found, this Step must run inside a Pipeline and the build_slug input should be set to $BITRISE_BUILD_SLUG."
+	case apiErr.StatusCode == http.StatusConflict:
+		return ConflictFailure, "The shared variables were changed by another workflow of the Pipeline, run the Step again or set the conflict_policy input."
+	case apiErr.StatusCode == http.StatusUnprocessableEntity || apiErr.StatusCode == http.StatusBadRequest:
+		return ValidationFailure, "The variables were rejected by the API, check the keys and values reported above."
+	case apiErr.Retryable:
+		return UnavailableFailure, "The Bitrise API is unavailable or throttled the requests, retry the build later or increase the retry_count input."
+	default:
+		return Failure, ""
+	}
 }
 
 func createEnvVarSharer(logger log.Logger) step.EnvVarSharer {
@@ -43,6 +103,7 @@ func createEnvVarSharer(logger log.Logger) step.EnvVarSharer {
 	inputParser := stepconf.NewInputParser(osEnvs)
 	envRepository := env.NewRepository()
 	secretKeysProvider := secretkeys.NewManager()
+	outputExporter := step.NewOutputExporter(command.NewFactory(envRepository))
 
-	return step.NewEnvVarSharer(logger, inputParser, envRepository, secretKeysProvider)
+	return step.NewEnvVarSharer(logger, inputParser, envRepository, secretKeysProvider, outputExporter)
 }
@@ -0,0 +1,63 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+)
+
+type ConflictPolicy string
+
+const (
+	ConflictPolicyOverwrite ConflictPolicy = "overwrite"
+	ConflictPolicySkip      ConflictPolicy = "skip"
+	ConflictPolicyFail      ConflictPolicy = "fail"
+)
+
+// resolveConflicts compares the env vars to share with the ones already shared by the pipeline. With the skip
+// policy already shared keys are left out, with the fail policy keys shared with a different value are an error.
+func (e EnvVarSharer) resolveConflicts(envVars []EnvVar, sharedEnvVars []api.SharedEnvVar, policy ConflictPolicy) ([]EnvVar, error) {
+	if policy == "" || policy == ConflictPolicyOverwrite {
+		return envVars, nil
+	}
+
+	sharedByKey := map[string]api.SharedEnvVar{}
+	for _, sharedEnvVar := range sharedEnvVars {
+		sharedByKey[sharedEnvVar.Key] = sharedEnvVar
+	}
+
+	var kept []EnvVar
+	var conflicts []string
+	for _, envVar := range envVars {
+		shared, isShared := sharedByKey[envVar.Key]
+		if !isShared {
+			kept = append(kept, envVar)
+			continue
+		}
+
+		if policy == ConflictPolicySkip {
+			e.logger.Warnf("%s (%s) is already shared, skipping it", envVar.Key, envVar.Source)
+			continue
+		}
+
+		if shared.Value != envVar.Value || shared.Sensitive != envVar.Sensitive {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s): shared value: %s, new value: %s", envVar.Key, envVar.Source, displayValue(shared.Value, shared.Sensitive), displayValue(envVar.Value, envVar.Sensitive)))
+			continue
+		}
+		kept = append(kept, envVar)
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("env vars are already shared with a different value:\n- %s", strings.Join(conflicts, "\n- "))
+	}
+
+	return kept, nil
+}
+
+func displayValue(value string, sensitive bool) string {
+	if sensitive {
+		return "[REDACTED] (sensitive)"
+	}
+	return previewValue(value)
+}
@@ -0,0 +1,60 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvVarSharer_resolveConflicts(t *testing.T) {
+	envVars := []EnvVar{
+		{Key: "VERSION_CODE", Value: "42", Source: "line 1"},
+		{Key: "BUILD_TYPE", Value: "debug", Source: "line 2"},
+		{Key: "API_TOKEN", Value: "new secret", Sensitive: true, Source: "line 3"},
+		{Key: "NEW_KEY", Value: "new", Source: "line 4"},
+	}
+	sharedEnvVars := []api.SharedEnvVar{
+		{Key: "VERSION_CODE", Value: "41"},
+		{Key: "BUILD_TYPE", Value: "debug"},
+		{Key: "API_TOKEN", Value: "old secret", Sensitive: true},
+	}
+
+	tests := []struct {
+		name    string
+		policy  ConflictPolicy
+		want    []EnvVar
+		wantErr string
+	}{
+		{
+			name:   "Overwrite",
+			policy: ConflictPolicyOverwrite,
+			want:   envVars,
+		},
+		{
+			name:   "Skip",
+			policy: ConflictPolicySkip,
+			want:   []EnvVar{{Key: "NEW_KEY", Value: "new", Source: "line 4"}},
+		},
+		{
+			name:   "Fail",
+			policy: ConflictPolicyFail,
+			wantErr: `env vars are already shared with a different value:
+- VERSION_CODE (line 1): shared value: "41", new value: "42"
+- API_TOKEN (line 3): shared value: [REDACTED] (sensitive), new value: [REDACTED] (sensitive)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := EnvVarSharer{logger: log.NewLogger()}
+			got, err := e.resolveConflicts(envVars, sharedEnvVars, tt.policy)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package step
+
+import (
+	"context"
+
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+)
+
+type DiffChange string
+
+const (
+	DiffChangeAdded     DiffChange = "added"
+	DiffChangeChanged   DiffChange = "changed"
+	DiffChangeUnchanged DiffChange = "unchanged"
+	DiffChangeDeleted   DiffChange = "deleted"
+)
+
+type diffEntry struct {
+	key    string
+	change DiffChange
+	old    string
+	new    string
+}
+
+// Diff prints how sharing the config would change the variables shared so far by the pipeline.
+func (e EnvVarSharer) Diff(ctx context.Context, config Config) error {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	client := api.NewBitriseClientWithOptions(config.AppURL, config.BuildSlug, config.BuildAPIToken, config.clientOptions(), e.logger)
+	sharedEnvVars, err := client.ListSharedEnvVars(ctx)
+	if err != nil {
+		return apiCallError(err, config.Timeout)
+	}
+
+	entries := diffEnvVars(config.EnvVars, config.DeletedKeys, sharedEnvVars)
+	changes := 0
+	for _, entry := range entries {
+		switch entry.change {
+		case DiffChangeAdded:
+			e.logger.Printf("+ %s: %s", entry.key, entry.new)
+		case DiffChangeChanged:
+			e.logger.Printf("~ %s: %s -> %s", entry.key, entry.old, entry.new)
+		case DiffChangeDeleted:
+			e.logger.Printf("- %s: %s", entry.key, entry.old)
+		default:
+			e.logger.Printf("  %s: %s", entry.key, entry.new)
+			continue
+		}
+		changes++
+	}
+
+	e.logger.Donef("%d of %d env vars would change", changes, len(entries))
+
+	return nil
+}
+
+// diffEnvVars compares the env vars to share and the keys to delete with the shared env vars.
+// Values of sensitive env vars are redacted.
+func diffEnvVars(envVars []EnvVar, deletedKeys []string, sharedEnvVars []api.SharedEnvVar) []diffEntry {
+	sharedByKey := map[string]api.SharedEnvVar{}
+	for _, sharedEnvVar := range sharedEnvVars {
+		sharedByKey[sharedEnvVar.Key] = sharedEnvVar
+	}
+
+	var entries []diffEntry
+	for _, key := range deletedKeys {
+		shared, isShared := sharedByKey[key]
+		if !isShared {
+			continue
+		}
+		entries = append(entries, diffEntry{key: key, change: DiffChangeDeleted, old: displayValue(shared.Value, shared.Sensitive)})
+	}
+
+	for _, envVar := range envVars {
+		entry := diffEntry{key: envVar.Key, new: displayValue(envVar.Value, envVar.Sensitive)}
+		shared, isShared := sharedByKey[envVar.Key]
+		switch {
+		case !isShared:
+			entry.change = DiffChangeAdded
+		case shared.Value != envVar.Value || shared.Sensitive != envVar.Sensitive:
+			entry.change = DiffChangeChanged
+			entry.old = displayValue(shared.Value, shared.Sensitive)
+		default:
+			entry.change = DiffChangeUnchanged
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
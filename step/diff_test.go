@@ -0,0 +1,32 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_diffEnvVars(t *testing.T) {
+	envVars := []EnvVar{
+		{Key: "NEW_KEY", Value: "new"},
+		{Key: "VERSION_CODE", Value: "42"},
+		{Key: "BUILD_TYPE", Value: "debug"},
+		{Key: "API_TOKEN", Value: "new secret", Sensitive: true},
+	}
+	sharedEnvVars := []api.SharedEnvVar{
+		{Key: "VERSION_CODE", Value: "41"},
+		{Key: "BUILD_TYPE", Value: "debug"},
+		{Key: "API_TOKEN", Value: "old secret", Sensitive: true},
+		{Key: "OLD_KEY", Value: "old"},
+	}
+
+	got := diffEnvVars(envVars, []string{"OLD_KEY", "NOT_SHARED"}, sharedEnvVars)
+	require.Equal(t, []diffEntry{
+		{key: "OLD_KEY", change: DiffChangeDeleted, old: `"old"`},
+		{key: "NEW_KEY", change: DiffChangeAdded, new: `"new"`},
+		{key: "VERSION_CODE", change: DiffChangeChanged, old: `"41"`, new: `"42"`},
+		{key: "BUILD_TYPE", change: DiffChangeUnchanged, new: `"debug"`},
+		{key: "API_TOKEN", change: DiffChangeChanged, old: "[REDACTED] (sensitive)", new: "[REDACTED] (sensitive)"},
+	}, got)
+}
@@ -0,0 +1,80 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+)
+
+// Limits of the shared env vars, zero values mean no limit.
+type Limits struct {
+	MaxKeyLength       int
+	MaxValueLength     int
+	MaxVariableCount   int
+	MaxRequestBodySize int
+}
+
+type envVarSize struct {
+	envVar      EnvVar
+	requestSize int
+	problems    []string
+}
+
+// checkLimits validates the env vars against the limits before any of them is sent, and reports every
+// offending env var with its sizes.
+func (e EnvVarSharer) checkLimits(envVars []EnvVar, limits Limits) error {
+	var offending []envVarSize
+	for _, envVar := range envVars {
+		requestSize, err := api.RequestBodySize([]api.SharedEnvVar{envVar.apiEnvVar()})
+		if err != nil {
+			return err
+		}
+
+		size := envVarSize{envVar: envVar, requestSize: requestSize}
+		if limits.MaxKeyLength > 0 && len(envVar.Key) > limits.MaxKeyLength {
+			size.problems = append(size.problems, fmt.Sprintf("key is longer than %d bytes", limits.MaxKeyLength))
+		}
+		if limits.MaxValueLength > 0 && len(envVar.Value) > limits.MaxValueLength {
+			size.problems = append(size.problems, fmt.Sprintf("value is longer than %d bytes", limits.MaxValueLength))
+		}
+		if limits.MaxRequestBodySize > 0 && requestSize > limits.MaxRequestBodySize {
+			size.problems = append(size.problems, fmt.Sprintf("request is larger than %d bytes", limits.MaxRequestBodySize))
+		}
+		if len(size.problems) > 0 {
+			offending = append(offending, size)
+		}
+	}
+
+	bodySize, err := api.RequestBodySize(Config{EnvVars: envVars}.APIEnvVars())
+	if err != nil {
+		return err
+	}
+	e.logger.Printf("Payload: %d env vars, %d bytes", len(envVars), bodySize)
+
+	var problems []string
+	if limits.MaxVariableCount > 0 && len(envVars) > limits.MaxVariableCount {
+		problems = append(problems, fmt.Sprintf("%d env vars are shared, the limit is %d", len(envVars), limits.MaxVariableCount))
+	}
+	if len(offending) > 0 {
+		problems = append(problems, fmt.Sprintf("%d env vars exceed the size limits:\n%s", len(offending), formatSizeTable(offending)))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("payload limits exceeded:\n%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+func formatSizeTable(sizes []envVarSize) string {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KEY\tSOURCE\tKEY LENGTH\tVALUE LENGTH\tREQUEST SIZE\tPROBLEM")
+	for _, size := range sizes {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", size.envVar.Key, size.envVar.Source, len(size.envVar.Key), len(size.envVar.Value), size.requestSize, strings.Join(size.problems, ", "))
+	}
+	_ = w.Flush()
+
+	return strings.TrimSuffix(table.String(), "\n")
+}
@@ -0,0 +1,55 @@
+package step
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvVarSharer_checkLimits(t *testing.T) {
+	envVars := []EnvVar{
+		{Key: "SHORT", Value: "value", Source: "line 1"},
+		{Key: "LONG_KEY_" + strings.Repeat("K", 20), Value: "value", Source: "line 2"},
+		{Key: "LONG_VALUE", Value: strings.Repeat("v", 100), Source: "line 3"},
+	}
+
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr string
+	}{
+		{
+			name:   "No limits",
+			limits: Limits{},
+		},
+		{
+			name:   "Within the limits",
+			limits: Limits{MaxKeyLength: 29, MaxValueLength: 100, MaxVariableCount: 3, MaxRequestBodySize: 1000},
+		},
+		{
+			name:   "Offending env vars are reported in a table",
+			limits: Limits{MaxKeyLength: 20, MaxValueLength: 50, MaxVariableCount: 2, MaxRequestBodySize: 140},
+			wantErr: `payload limits exceeded:
+3 env vars are shared, the limit is 2
+2 env vars exceed the size limits:
+KEY                            SOURCE  KEY LENGTH  VALUE LENGTH  REQUEST SIZE  PROBLEM
+LONG_KEY_KKKKKKKKKKKKKKKKKKKK  line 2  29          5             94            key is longer than 20 bytes
+LONG_VALUE                     line 3  10          100           170           value is longer than 50 bytes, request is larger than 140 bytes`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := EnvVarSharer{
+				logger: log.NewLogger(),
+			}
+			err := e.checkLimits(envVars, tt.limits)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package step
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem of a variable declaration found by the validate mode.
+type Diagnostic struct {
+	Source   string
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Source, d.Severity, d.Message)
+}
+
+type lintOptions struct {
+	secretKeys      []string
+	keyValidator    KeyValidator
+	environment     map[string]string
+	duplicatePolicy DuplicatePolicy
+	secretDetection SecretDetection
+}
+
+// lintEnvVars parses the variables and reports every problem of the declarations, without calling the API. Parse errors stop the validation, as the rest of the input can't be interpreted.
+func (e EnvVarSharer) lintEnvVars(input Input) ([]Diagnostic, error) {
+	declarations, err := parseInputDeclarations(input)
+	if err != nil {
+		return nil, err
+	}
+
+	keyValidator, err := NewKeyValidator(input.ReservedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return lintDeclarations(declarations, lintOptions{
+		secretKeys:      e.secretKeysProvider.Load(e.envRepository),
+		keyValidator:    keyValidator,
+		environment:     e.environment(),
+		duplicatePolicy: input.DuplicatePolicy,
+		secretDetection: input.SecretDetection,
+	}), nil
+}
+
+// lintEntry is an env var which would be shared, index is the position of the declaration sharing it.
+type lintEntry struct {
+	index       int
+	declaration declaration
+	key         string
+	value       string
+	pattern     bool
+}
+
+type indexedDiagnostic struct {
+	index int
+	Diagnostic
+}
+
+// lintDeclarations walks the declarations the same way as resolveEnvVars: patterns are expanded against the
+// environment and exclusions remove the env vars selected by the preceding lines. The env vars left are checked
+// once every declaration is applied, diagnostics are ordered by the declaration they belong to.
+func lintDeclarations(declarations []declaration, options lintOptions) []Diagnostic {
+	var diagnostics []indexedDiagnostic
+	report := func(index int, severity Severity, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, indexedDiagnostic{
+			index:      index,
+			Diagnostic: Diagnostic{Source: declarations[index].source, Severity: severity, Message: fmt.Sprintf(format, args...)},
+		})
+	}
+
+	var entries []lintEntry
+	var deletions []int
+	for i, d := range declarations {
+		switch {
+		case d.exclude:
+			entries = excludeLintEntries(entries, d)
+		case d.pattern:
+			var skippedKeys []string
+			for _, key := range matchingKeys(d.key, options.environment) {
+				if err := options.keyValidator.Validate(key); err != nil {
+					skippedKeys = append(skippedKeys, key)
+					continue
+				}
+				entries = append(entries, lintEntry{index: i, declaration: d, key: key, value: options.environment[key], pattern: true})
+			}
+			if len(skippedKeys) > 0 {
+				report(i, SeverityWarning, "%s matched env vars which can't be shared, skipping them: %s", d.key, strings.Join(skippedKeys, ", "))
+			}
+		default:
+			if err := options.keyValidator.Validate(d.key); err != nil {
+				report(i, SeverityError, "%s %s", d.key, err)
+				continue
+			}
+			if d.remove {
+				deletions = append(deletions, i)
+				continue
+			}
+			entries = append(entries, lintEntry{index: i, declaration: d, key: d.key, value: d.value})
+		}
+	}
+
+	sharedSources := map[string]string{}
+	for _, entry := range entries {
+		if source, declared := sharedSources[entry.key]; declared {
+			severity := SeverityWarning
+			if options.duplicatePolicy == DuplicatePolicyError {
+				severity = SeverityError
+			}
+			report(entry.index, severity, "%s is already declared at %s", entry.key, source)
+		} else {
+			sharedSources[entry.key] = entry.declaration.source
+		}
+
+		d := entry.declaration
+		value := entry.value
+		if d.lookup && !entry.pattern {
+			var isSet bool
+			if value, isSet = options.environment[entry.key]; !isSet {
+				report(entry.index, SeverityWarning, "%s is not set in the current environment", entry.key)
+				continue
+			}
+		}
+
+		declaredNonSensitive := d.sensitive != nil && !*d.sensitive
+		if d.isSensitive(entry.key, options.secretKeys) || declaredNonSensitive {
+			continue
+		}
+		if kind := detectSecret(value); kind != "" {
+			severity := SeverityWarning
+			if options.secretDetection == SecretDetectionFail {
+				severity = SeverityError
+			}
+			report(entry.index, severity, "%s: possible %s, mark it with sensitive: or nonsensitive:", entry.key, kind)
+		}
+	}
+
+	for _, i := range deletions {
+		if source, shared := sharedSources[declarations[i].key]; shared {
+			report(i, SeverityError, "%s is deleted and shared at %s", declarations[i].key, source)
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].index < diagnostics[j].index
+	})
+	var sorted []Diagnostic
+	for _, diagnostic := range diagnostics {
+		sorted = append(sorted, diagnostic.Diagnostic)
+	}
+	return sorted
+}
+
+func excludeLintEntries(entries []lintEntry, exclusion declaration) []lintEntry {
+	var kept []lintEntry
+	for _, entry := range entries {
+		excluded := entry.key == exclusion.key
+		if exclusion.pattern {
+			excluded, _ = path.Match(exclusion.key, entry.key)
+		}
+		if !excluded {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// reportDiagnostics prints the diagnostics and fails if any of them is an error.
+func (e EnvVarSharer) reportDiagnostics(diagnostics []Diagnostic) error {
+	var errorCount int
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			errorCount++
+			e.logger.Errorf("%s", diagnostic)
+		} else {
+			e.logger.Warnf("%s", diagnostic)
+		}
+	}
+
+	if errorCount > 0 {
+		return fmt.Errorf("%d errors found in the variables", errorCount)
+	}
+	if len(diagnostics) > 0 {
+		e.logger.Donef("Variables are valid, %d warnings", len(diagnostics))
+	} else {
+		e.logger.Donef("Variables are valid")
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package step
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const (
+	ReportPathOutputKey = "SHARE_PIPELINE_VARIABLES_REPORT_PATH"
+	SharedKeysOutputKey = "SHARE_PIPELINE_VARIABLES_SHARED_KEYS"
+)
+
+// OutputExporter exposes values to the subsequent steps of the workflow.
+type OutputExporter interface {
+	ExportOutput(key, value string) error
+	ExportEnvVar(key, value string, sensitive bool) error
+}
+
+type envmanExporter struct {
+	cmdFactory command.Factory
+}
+
+func NewOutputExporter(cmdFactory command.Factory) OutputExporter {
+	return envmanExporter{cmdFactory: cmdFactory}
+}
+
+func (e envmanExporter) ExportOutput(key, value string) error {
+	return e.ExportEnvVar(key, value, false)
+}
+
+// ExportEnvVar adds the env var to the env store of the workflow, sensitive env vars are stored as secrets.
+// The value is piped through the stdin of envman, as values can exceed the size limit of a command line argument.
+// Values are already resolved, so envman must not expand the env var references in them again.
+func (e envmanExporter) ExportEnvVar(key, value string, sensitive bool) error {
+	args := []string{"add", "--key", key, "--no-expand"}
+	if sensitive {
+		args = append(args, "--sensitive")
+	}
+
+	cmd := e.cmdFactory.Create("envman", args, &command.Opts{Stdin: strings.NewReader(value)})
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("failed to export %s with envman: %w, output: %s", key, err, out)
+	}
+	return nil
+}
+
+// exportEnvVars makes the shared env vars available for the subsequent steps of the current workflow.
+func (e EnvVarSharer) exportEnvVars(envVars []EnvVar) error {
+	for _, envVar := range envVars {
+		if err := e.outputExporter.ExportEnvVar(envVar.Key, envVar.Value, envVar.Sensitive); err != nil {
+			return err
+		}
+	}
+	if len(envVars) > 0 {
+		e.logger.Printf("Exported %d env vars into the current workflow", len(envVars))
+	}
+	return nil
+}
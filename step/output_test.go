@@ -0,0 +1,35 @@
+package step
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_envmanExporter_ExportEnvVar(t *testing.T) {
+	binDir := t.TempDir()
+	argsPath := filepath.Join(binDir, "args")
+	valuePath := filepath.Join(binDir, "value")
+	script := "#!/bin/sh\necho \"$@\" > " + argsPath + "\ncat > " + valuePath + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "envman"), []byte(script), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// larger than the 128 KiB limit of a single command line argument on Linux
+	value := strings.Repeat("a", 200*1024) + "$HOME"
+
+	exporter := NewOutputExporter(command.NewFactory(env.NewRepository()))
+	require.NoError(t, exporter.ExportEnvVar("API_TOKEN", value, true))
+
+	args, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	require.Equal(t, "add --key API_TOKEN --no-expand --sensitive\n", string(args))
+
+	exported, err := os.ReadFile(valuePath)
+	require.NoError(t, err)
+	require.Equal(t, value, string(exported))
+}
@@ -0,0 +1,296 @@
+package step
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	heredocOperator    = "<<"
+	exclusionPrefix    = "!"
+	deletionPrefix     = "-"
+	commentPrefix      = "#"
+	exportPrefix       = "export"
+	sensitiveMarker    = "sensitive:"
+	nonSensitiveMarker = "nonsensitive:"
+	doubleQuote        = '"'
+	singleQuote        = '\''
+	whitespace         = " \t"
+)
+
+type declaration struct {
+	source  string
+	key     string
+	value   string
+	lookup  bool
+	pattern bool
+	exclude bool
+	// remove removes the key from the variables shared so far by the pipeline
+	remove bool
+	// sensitive overrides the sensitivity detected by the secret keys list when set
+	sensitive *bool
+}
+
+func (d declaration) isSensitive(key string, secretKeys []string) bool {
+	if d.sensitive != nil {
+		return *d.sensitive
+	}
+	return slices.Contains(secretKeys, key)
+}
+
+// parseDeclarations parses a variables list read from origin, which is used to describe the source
+// of each declaration. An empty origin stands for the variables input.
+func parseDeclarations(input, origin string) ([]declaration, error) {
+	var declarations []declaration
+
+	lines := strings.Split(input, "\n")
+	for i := 0; i < len(lines); i++ {
+		source := formatSource(origin, i+1)
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, commentPrefix) {
+			// empty and comment lines are ignored
+			continue
+		}
+
+		rawLine, sensitive := cutSensitivityMarker(strings.TrimLeft(lines[i], whitespace))
+		rawLine, exported := cutExportPrefix(rawLine)
+		if sensitive != nil || exported {
+			line = strings.TrimSpace(rawLine)
+		}
+
+		if strings.HasPrefix(line, exclusionPrefix) {
+			if sensitive != nil {
+				return nil, fmt.Errorf("%s: exclusion can't have a sensitivity marker: %s", source, strings.TrimSpace(lines[i]))
+			}
+
+			key := strings.TrimSpace(strings.TrimPrefix(line, exclusionPrefix))
+			if key == "" || strings.Contains(key, "=") {
+				return nil, fmt.Errorf("%s: exclusion should be in a format: !KEY or !PATTERN: %s", source, line)
+			}
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern %s: %w", source, key, err)
+			}
+
+			declarations = append(declarations, declaration{
+				source:  source,
+				key:     key,
+				pattern: isPattern(key),
+				exclude: true,
+			})
+			continue
+		}
+
+		if isDeletion(line) {
+			if sensitive != nil {
+				return nil, fmt.Errorf("%s: deletion can't have a sensitivity marker: %s", source, strings.TrimSpace(lines[i]))
+			}
+
+			key := strings.TrimPrefix(line, deletionPrefix)
+			if strings.Contains(key, "=") || strings.ContainsAny(key, whitespace) {
+				return nil, fmt.Errorf("%s: deletion should be in a format: -KEY: %s", source, line)
+			}
+			if isPattern(key) {
+				return nil, fmt.Errorf("%s: deletion can't be a pattern: %s", source, line)
+			}
+
+			declarations = append(declarations, declaration{
+				source: source,
+				key:    key,
+				remove: true,
+			})
+			continue
+		}
+
+		if key, delimiter, ok := cutHeredoc(line); ok {
+			if key == "" || delimiter == "" {
+				return nil, fmt.Errorf("%s: heredoc should be in a format: KEY<<DELIMITER: %s", source, line)
+			}
+
+			end := -1
+			for j := i + 1; j < len(lines); j++ {
+				if strings.TrimSpace(lines[j]) == delimiter {
+					end = j
+					break
+				}
+			}
+			if end == -1 {
+				return nil, fmt.Errorf("%s: heredoc of %s is not terminated: closing %s line not found", source, key, delimiter)
+			}
+
+			declarations = append(declarations, declaration{
+				source:    source,
+				key:       key,
+				value:     strings.Join(lines[i+1:end], "\n"),
+				sensitive: sensitive,
+			})
+			i = end
+			continue
+		}
+
+		// the raw line is used as trailing whitespace belongs to a quoted value
+		key, rawValue, hasValue := strings.Cut(rawLine, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			// line starting with = is invalid
+			return nil, fmt.Errorf("%s: env var should be in a format: KEY=value or KEY: %s", source, line)
+		}
+
+		rawValue = strings.TrimLeft(rawValue, whitespace)
+		if rawValue != "" && (rawValue[0] == doubleQuote || rawValue[0] == singleQuote) {
+			valueLines := append([]string{rawValue[1:]}, lines[i+1:]...)
+			value, consumedLines, err := readQuotedValue(rawValue[0], valueLines)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid value of %s: %w", source, key, err)
+			}
+
+			declarations = append(declarations, declaration{
+				source:    source,
+				key:       key,
+				value:     value,
+				sensitive: sensitive,
+			})
+			i += consumedLines
+			continue
+		}
+
+		value := strings.TrimSpace(cutInlineComment(rawValue))
+		if isPattern(key) {
+			if hasValue {
+				return nil, fmt.Errorf("%s: a pattern can't have a value: %s", source, line)
+			}
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern %s: %w", source, key, err)
+			}
+		}
+
+		declarations = append(declarations, declaration{
+			source:    source,
+			key:       key,
+			value:     value,
+			lookup:    !hasValue,
+			pattern:   isPattern(key),
+			sensitive: sensitive,
+		})
+	}
+
+	return declarations, nil
+}
+
+// cutSensitivityMarker removes the sensitive: or nonsensitive: marker from the beginning of the line and returns
+// the sensitivity override it stands for.
+func cutSensitivityMarker(line string) (string, *bool) {
+	sensitive := strings.HasPrefix(line, sensitiveMarker)
+	if !sensitive && !strings.HasPrefix(line, nonSensitiveMarker) {
+		return line, nil
+	}
+
+	_, rest, _ := strings.Cut(line, ":")
+	return rest, &sensitive
+}
+
+// cutExportPrefix removes the export keyword of the export KEY=value lines written by shell scripts.
+func cutExportPrefix(line string) (string, bool) {
+	rest := strings.TrimPrefix(line, exportPrefix)
+	if len(rest) == len(line) || rest == "" || !strings.ContainsRune(whitespace, rune(rest[0])) {
+		return line, false
+	}
+	return strings.TrimLeft(rest, whitespace), true
+}
+
+// cutHeredoc splits a KEY<<DELIMITER line. A << appearing after the first = belongs to the value of a KEY=value line.
+func cutHeredoc(line string) (string, string, bool) {
+	operatorIndex := strings.Index(line, heredocOperator)
+	if operatorIndex == -1 {
+		return "", "", false
+	}
+	if assignIndex := strings.Index(line, "="); assignIndex != -1 && assignIndex < operatorIndex {
+		return "", "", false
+	}
+
+	key := strings.TrimSpace(line[:operatorIndex])
+	delimiter := strings.TrimSpace(line[operatorIndex+len(heredocOperator):])
+	return key, delimiter, true
+}
+
+func formatSource(origin string, line int) string {
+	if origin == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("line %d of %s", line, origin)
+}
+
+// isDeletion reports whether the line is a -KEY deletion, a dash followed by whitespace is not a deletion.
+func isDeletion(line string) bool {
+	return len(line) > len(deletionPrefix) && strings.HasPrefix(line, deletionPrefix) && !strings.ContainsAny(line[len(deletionPrefix):len(deletionPrefix)+1], whitespace)
+}
+
+// isInlineComment reports whether s is a whitespace separated # comment, like the one following a value in
+// KEY="value" # comment.
+func isInlineComment(s string) bool {
+	trimmed := strings.TrimLeft(s, whitespace)
+	return len(trimmed) < len(s) && strings.HasPrefix(trimmed, commentPrefix)
+}
+
+// cutInlineComment removes the comment from an unquoted value, a # is only a comment if it follows whitespace,
+// so URL fragments like https://example.com/#readme are kept.
+func cutInlineComment(value string) string {
+	for i := 1; i < len(value); i++ {
+		if strings.HasPrefix(value[i:], commentPrefix) && strings.ContainsRune(whitespace, rune(value[i-1])) {
+			return value[:i]
+		}
+	}
+	return value
+}
+
+func isPattern(key string) bool {
+	return strings.ContainsAny(key, "*?[")
+}
+
+// readQuotedValue reads a quoted value which starts right after the opening quote in lines[0] and may continue
+// in the following lines. It returns the unquoted value and the number of additional lines it spans.
+// Single-quoted values are taken verbatim, double-quoted values support backslash escape sequences.
+func readQuotedValue(quote byte, lines []string) (string, int, error) {
+	var value strings.Builder
+	for lineIndex, line := range lines {
+		if lineIndex > 0 {
+			value.WriteByte('\n')
+		}
+
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case c == quote:
+				if trailing := strings.TrimSpace(line[i+1:]); trailing != "" && !isInlineComment(line[i+1:]) {
+					return "", 0, fmt.Errorf("unexpected characters after the closing quote: %s", trailing)
+				}
+				return value.String(), lineIndex, nil
+			case c == '\\' && quote == doubleQuote && i+1 < len(line):
+				i++
+				value.WriteString(unescape(line[i]))
+			default:
+				value.WriteByte(c)
+			}
+		}
+	}
+
+	return "", 0, fmt.Errorf("closing %c quote not found", quote)
+}
+
+func unescape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case '\\', '"', '$':
+		return string(c)
+	default:
+		// unknown escape sequences are kept as is
+		return "\\" + string(c)
+	}
+}
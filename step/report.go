@@ -0,0 +1,130 @@
+package step
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+)
+
+const reportFileName = "share-pipeline-variables-report.json"
+
+type ReportStatus string
+
+const (
+	ReportStatusShared ReportStatus = "shared"
+	ReportStatusFailed ReportStatus = "failed"
+	ReportStatusDryRun ReportStatus = "dry_run"
+)
+
+// Report describes what the step handed to the next stages, values are only included as hashes. Values of
+// sensitive env vars are not hashed, as short secrets could be brute-forced from a plain hash.
+type Report struct {
+	Timestamp       time.Time      `json:"timestamp"`
+	BuildSlug       string         `json:"build_slug"`
+	Status          ReportStatus   `json:"status"`
+	StatusCode      int            `json:"status_code,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	RequestBodySize int            `json:"request_body_size"`
+	EnvVars         []ReportEnvVar `json:"env_vars"`
+	DeletedKeys     []string       `json:"deleted_keys,omitempty"`
+}
+
+type ReportEnvVar struct {
+	Key         string `json:"key"`
+	Sensitive   bool   `json:"sensitive"`
+	ValueSHA256 string `json:"value_sha256,omitempty"`
+	ValueSize   int    `json:"value_size"`
+	Source      string `json:"source"`
+}
+
+// newReport describes the env vars the step shared or tried to share, statusCode is the status of the last share
+// response and err is the error of sharing them.
+func newReport(config Config, envVars []EnvVar, statusCode int, err error, now time.Time) (Report, error) {
+	report := Report{
+		Timestamp:   now.UTC(),
+		BuildSlug:   config.BuildSlug,
+		Status:      ReportStatusShared,
+		StatusCode:  statusCode,
+		EnvVars:     []ReportEnvVar{},
+		DeletedKeys: config.DeletedKeys,
+	}
+	switch {
+	case config.DryRun:
+		report.Status = ReportStatusDryRun
+	case err != nil:
+		report.Status = ReportStatusFailed
+		report.Error = err.Error()
+
+		var apiErr *api.Error
+		if errors.As(err, &apiErr) {
+			report.StatusCode = apiErr.StatusCode
+		}
+	}
+
+	bodySize, sizeErr := api.RequestBodySize(Config{EnvVars: envVars}.APIEnvVars())
+	if sizeErr != nil {
+		return Report{}, sizeErr
+	}
+	report.RequestBodySize = bodySize
+
+	for _, envVar := range envVars {
+		reportEnvVar := ReportEnvVar{
+			Key:       envVar.Key,
+			Sensitive: envVar.Sensitive,
+			ValueSize: len(envVar.Value),
+			Source:    envVar.Source,
+		}
+		if !envVar.Sensitive {
+			hash := sha256.Sum256([]byte(envVar.Value))
+			reportEnvVar.ValueSHA256 = hex.EncodeToString(hash[:])
+		}
+		report.EnvVars = append(report.EnvVars, reportEnvVar)
+	}
+
+	return report, nil
+}
+
+// exportReport writes the report into the deploy dir and exports its path and the shared keys as step outputs.
+func (e EnvVarSharer) exportReport(config Config, envVars []EnvVar, statusCode int, shareErr error) error {
+	report, err := newReport(config, envVars, statusCode, shareErr, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if config.DeployDir != "" {
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		pth := filepath.Join(config.DeployDir, reportFileName)
+		if err := os.WriteFile(pth, content, 0644); err != nil {
+			return fmt.Errorf("failed to write the share report: %w", err)
+		}
+		e.logger.Printf("Share report: %s", pth)
+
+		if err := e.outputExporter.ExportOutput(ReportPathOutputKey, pth); err != nil {
+			return err
+		}
+	}
+
+	if report.Status == ReportStatusShared {
+		var keys []string
+		for _, envVar := range envVars {
+			keys = append(keys, envVar.Key)
+		}
+		if err := e.outputExporter.ExportOutput(SharedKeysOutputKey, strings.Join(keys, "\n")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package step
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/api"
+	"github.com/bitrise-steplib/bitrise-step-share-pipeline-variable/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newReport(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	envVars := []EnvVar{
+		{Key: "BUILD_TYPE", Value: "debug", Source: "line 1"},
+		{Key: "API_TOKEN", Value: "secret", Sensitive: true, Source: "line 2 of build.env"},
+	}
+	wantEnvVars := []ReportEnvVar{
+		{Key: "BUILD_TYPE", ValueSHA256: "0b8e9e995d8d77f1e4770f0f79665aee6f3f70247b3735422daba73df4c3096f", ValueSize: 5, Source: "line 1"},
+		{Key: "API_TOKEN", Sensitive: true, ValueSize: 6, Source: "line 2 of build.env"},
+	}
+
+	tests := []struct {
+		name       string
+		config     Config
+		statusCode int
+		err        error
+		want       Report
+	}{
+		{
+			name:       "Shared",
+			config:     Config{BuildSlug: "slug", DeletedKeys: []string{"OLD_KEY"}},
+			statusCode: http.StatusNoContent,
+			want: Report{
+				Timestamp:       now.UTC(),
+				BuildSlug:       "slug",
+				Status:          ReportStatusShared,
+				StatusCode:      http.StatusNoContent,
+				RequestBodySize: 132,
+				EnvVars:         wantEnvVars,
+				DeletedKeys:     []string{"OLD_KEY"},
+			},
+		},
+		{
+			name:       "Failed",
+			config:     Config{BuildSlug: "slug"},
+			statusCode: http.StatusConflict,
+			err:        &api.BatchError{Batch: 1, BatchCount: 2, Err: &api.Error{URL: "https://app.bitrise.io", StatusCode: http.StatusConflict}},
+			want: Report{
+				Timestamp:       now.UTC(),
+				BuildSlug:       "slug",
+				Status:          ReportStatusFailed,
+				StatusCode:      http.StatusConflict,
+				Error:           "batch 1/2 () failed: request to https://app.bitrise.io failed: status code should be 2xx (409), no env vars were shared",
+				RequestBodySize: 132,
+				EnvVars:         wantEnvVars,
+			},
+		},
+		{
+			name:   "Dry run",
+			config: Config{BuildSlug: "slug", DryRun: true},
+			want: Report{
+				Timestamp:       now.UTC(),
+				BuildSlug:       "slug",
+				Status:          ReportStatusDryRun,
+				RequestBodySize: 132,
+				EnvVars:         wantEnvVars,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newReport(tt.config, envVars, tt.statusCode, tt.err, now)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEnvVarSharer_exportReport(t *testing.T) {
+	deployDir := t.TempDir()
+	reportPath := filepath.Join(deployDir, "share-pipeline-variables-report.json")
+
+	outputExporter := mocks.NewOutputExporter(t)
+	outputExporter.On("ExportOutput", ReportPathOutputKey, reportPath).Return(nil).Once()
+	outputExporter.On("ExportOutput", SharedKeysOutputKey, "BUILD_TYPE\nAPI_TOKEN").Return(nil).Once()
+
+	e := EnvVarSharer{
+		logger:         log.NewLogger(),
+		outputExporter: outputExporter,
+	}
+	envVars := []EnvVar{
+		{Key: "BUILD_TYPE", Value: "debug", Source: "line 1"},
+		{Key: "API_TOKEN", Value: "secret", Sensitive: true, Source: "line 2"},
+	}
+	require.NoError(t, e.exportReport(Config{BuildSlug: "slug", DeployDir: deployDir}, envVars, http.StatusNoContent, nil))
+
+	content, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "secret")
+	// sha256 of "secret"
+	require.NotContains(t, string(content), "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b")
+	require.Equal(t, 1, strings.Count(string(content), `"value_sha256"`))
+
+	var report Report
+	require.NoError(t, json.Unmarshal(content, &report))
+	require.Equal(t, ReportStatusShared, report.Status)
+	require.Equal(t, http.StatusNoContent, report.StatusCode)
+	require.Equal(t, 2, len(report.EnvVars))
+}
+
+func TestEnvVarSharer_exportReport_Failed(t *testing.T) {
+	deployDir := t.TempDir()
+
+	outputExporter := mocks.NewOutputExporter(t)
+	outputExporter.On("ExportOutput", ReportPathOutputKey, filepath.Join(deployDir, "share-pipeline-variables-report.json")).Return(nil).Once()
+
+	e := EnvVarSharer{
+		logger:         log.NewLogger(),
+		outputExporter: outputExporter,
+	}
+	envVars := []EnvVar{{Key: "BUILD_TYPE", Value: "debug", Source: "line 1"}}
+	require.NoError(t, e.exportReport(Config{BuildSlug: "slug", DeployDir: deployDir}, envVars, 0, errors.New("connection refused")))
+}
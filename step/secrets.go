@@ -0,0 +1,74 @@
+package step
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type SecretDetection string
+
+const (
+	SecretDetectionOff           SecretDetection = "off"
+	SecretDetectionMarkSensitive SecretDetection = "mark_sensitive"
+	SecretDetectionFail          SecretDetection = "fail"
+)
+
+const (
+	minHighEntropyLength = 32
+	minHighEntropy       = 4.3
+)
+
+type secretFormat struct {
+	name   string
+	regexp *regexp.Regexp
+}
+
+var secretFormats = []secretFormat{
+	{name: "GitHub token", regexp: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{name: "AWS access key", regexp: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "Slack token", regexp: regexp.MustCompile(`\bxox[abprse]-[A-Za-z0-9-]{10,}`)},
+	{name: "Slack webhook URL", regexp: regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9/]+`)},
+	{name: "JSON Web Token", regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{name: "PEM private key", regexp: regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)},
+}
+
+// detectSecret returns the kind of credential the value looks like, or an empty string if it doesn't look like one.
+// Well-known credential formats are checked first, then every word of the value (split at whitespace and URL
+// separators) is checked for being a long high-entropy string, like a generated token.
+func detectSecret(value string) string {
+	for _, format := range secretFormats {
+		if format.regexp.MatchString(value) {
+			return format.name
+		}
+	}
+
+	words := strings.FieldsFunc(value, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`/:?&=.,;"'`, r)
+	})
+	for _, word := range words {
+		if len(word) >= minHighEntropyLength && shannonEntropy(word) >= minHighEntropy {
+			return "high-entropy string"
+		}
+	}
+
+	return ""
+}
+
+// shannonEntropy returns the average number of bits needed to encode a character of s.
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	length := 0
+	for _, c := range s {
+		counts[c]++
+		length++
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(length)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -1,8 +1,14 @@
 package step
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/v2/secretkeys"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
@@ -12,51 +18,128 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+type MissingVariablePolicy string
+
+const (
+	MissingVariablePolicyFail        MissingVariablePolicy = "fail"
+	MissingVariablePolicyWarnAndSkip MissingVariablePolicy = "warn_and_skip"
+	MissingVariablePolicyShareEmpty  MissingVariablePolicy = "share_empty"
+)
+
+type DuplicatePolicy string
+
+const (
+	DuplicatePolicyError     DuplicatePolicy = "error"
+	DuplicatePolicyFirstWins DuplicatePolicy = "first_wins"
+	DuplicatePolicyLastWins  DuplicatePolicy = "last_wins"
+)
+
+type Mode string
+
+const (
+	ModeShare    Mode = "share"
+	ModeList     Mode = "list"
+	ModeValidate Mode = "validate"
+)
+
 type Input struct {
-	EnvVars       string `env:"variables,required"`
-	AppURL        string `env:"app_url,required"`
-	BuildSlug     string `env:"build_slug,required"`
-	BuildAPIToken string `env:"build_api_token,required"`
+	Mode                  Mode                  `env:"mode,opt[share,list,validate]"`
+	EnvVars               string                `env:"variables"`
+	EnvVarsFile           string                `env:"variables_file"`
+	EnvVarsFormat         VariablesFormat       `env:"variables_format,opt[auto,lines,json,yaml]"`
+	MissingVariablePolicy MissingVariablePolicy `env:"missing_variable_policy,opt[fail,warn_and_skip,share_empty]"`
+	ReservedKeys          []string              `env:"reserved_keys,multiline"`
+	DuplicatePolicy       DuplicatePolicy       `env:"duplicate_policy,opt[error,first_wins,last_wins]"`
+	SecretDetection       SecretDetection       `env:"secret_detection,opt[off,mark_sensitive,fail]"`
+	ConflictPolicy        ConflictPolicy        `env:"conflict_policy,opt[overwrite,skip,fail]"`
+	DryRun                bool                  `env:"dry_run,opt[true,false]"`
+	MaxRequestBodySize    int                   `env:"max_request_body_size"`
+	MaxBatchSize          int                   `env:"max_batch_size"`
+	MaxKeyLength          int                   `env:"max_key_length"`
+	MaxValueLength        int                   `env:"max_value_length"`
+	MaxVariableCount      int                   `env:"max_variable_count"`
+	Timeout               int                   `env:"timeout"`
+	RetryCount            int                   `env:"retry_count"`
+	RetryWaitMin          int                   `env:"retry_wait_min"`
+	RetryWaitMax          int                   `env:"retry_wait_max"`
+	MaxRetryWait          int                   `env:"max_retry_wait"`
+	ExportToWorkflow      bool                  `env:"export_to_workflow,opt[true,false]"`
+	DeployDir             string                `env:"deploy_dir"`
+	AppURL                string                `env:"app_url"`
+	BuildSlug             string                `env:"build_slug"`
+	BuildAPIToken         stepconf.Secret       `env:"build_api_token"`
 }
 
 type EnvVar struct {
 	Key       string
 	Value     string
 	Sensitive bool
+	Source    string
 }
 
 type Config struct {
-	EnvVars       []EnvVar
-	AppURL        string
-	BuildSlug     string
-	BuildAPIToken string
+	Mode               Mode
+	EnvVars            []EnvVar
+	DeletedKeys        []string
+	AppURL             string
+	BuildSlug          string
+	BuildAPIToken      string
+	ConflictPolicy     ConflictPolicy
+	DryRun             bool
+	ExportToWorkflow   bool
+	MaxRequestBodySize int
+	MaxBatchSize       int
+	Timeout            time.Duration
+	RetryCount         int
+	RetryWaitMin       time.Duration
+	RetryWaitMax       time.Duration
+	MaxRetryWait       time.Duration
+	DeployDir          string
+	Diagnostics        []Diagnostic
+}
+
+func (v EnvVar) apiEnvVar() api.SharedEnvVar {
+	return api.SharedEnvVar{
+		Key:       v.Key,
+		Value:     v.Value,
+		Sensitive: v.Sensitive,
+	}
 }
 
 func (c Config) APIEnvVars() []api.SharedEnvVar {
 	var apiEnvVars []api.SharedEnvVar
 	for _, envVar := range c.EnvVars {
-		apiEnvVars = append(apiEnvVars, api.SharedEnvVar{
-			Key:       envVar.Key,
-			Value:     envVar.Value,
-			Sensitive: envVar.Sensitive,
-		})
+		apiEnvVars = append(apiEnvVars, envVar.apiEnvVar())
 	}
 	return apiEnvVars
 }
 
+func (c Config) clientOptions() api.ClientOptions {
+	return api.ClientOptions{
+		MaxBodySize:  c.MaxRequestBodySize,
+		MaxBatchSize: c.MaxBatchSize,
+		RetryMax:     c.RetryCount,
+		RetryWaitMin: c.RetryWaitMin,
+		RetryWaitMax: c.RetryWaitMax,
+		MaxRetryWait: c.MaxRetryWait,
+	}
+}
+
 type EnvVarSharer struct {
 	logger             log.Logger
 	inputParser        stepconf.InputParser
 	envRepository      env.Repository
 	secretKeysProvider secretkeys.Manager
+	outputExporter     OutputExporter
 }
 
-func NewEnvVarSharer(logger log.Logger, inputParser stepconf.InputParser, envRepository env.Repository, secretKeysProvider secretkeys.Manager) EnvVarSharer {
+func NewEnvVarSharer(logger log.Logger, inputParser stepconf.InputParser, envRepository env.Repository, secretKeysProvider secretkeys.Manager, outputExporter OutputExporter) EnvVarSharer {
 	return EnvVarSharer{
 		logger:             logger,
 		inputParser:        inputParser,
 		envRepository:      envRepository,
 		secretKeysProvider: secretKeysProvider,
+		outputExporter:     outputExporter,
 	}
 }
 
@@ -66,68 +149,617 @@ func (e EnvVarSharer) ProcessConfig() (*Config, error) {
 		return nil, err
 	}
 
-	stepconf.Print(input)
+	stepconf.Print(printableInput(input))
 	e.logger.Println()
 
+	config := &Config{
+		Mode:               input.Mode,
+		AppURL:             input.AppURL,
+		BuildSlug:          input.BuildSlug,
+		BuildAPIToken:      string(input.BuildAPIToken),
+		ConflictPolicy:     input.ConflictPolicy,
+		DryRun:             input.DryRun,
+		ExportToWorkflow:   input.ExportToWorkflow,
+		MaxRequestBodySize: input.MaxRequestBodySize,
+		MaxBatchSize:       input.MaxBatchSize,
+		Timeout:            time.Duration(input.Timeout) * time.Second,
+		RetryCount:         input.RetryCount,
+		RetryWaitMin:       time.Duration(input.RetryWaitMin) * time.Second,
+		RetryWaitMax:       time.Duration(input.RetryWaitMax) * time.Second,
+		MaxRetryWait:       time.Duration(input.MaxRetryWait) * time.Second,
+		DeployDir:          input.DeployDir,
+	}
+	if input.Mode == ModeValidate {
+		diagnostics, err := e.lintEnvVars(input)
+		if err != nil {
+			return nil, err
+		}
+		config.Diagnostics = diagnostics
+		return config, nil
+	}
+
+	// a dry run doesn't call the API, so it can be used outside of a Pipeline too
+	if !(input.DryRun && input.Mode == ModeShare) {
+		if err := checkAPIInputs(input); err != nil {
+			return nil, err
+		}
+	}
+	if input.Mode == ModeList {
+		return config, nil
+	}
+
+	envVars, deletedKeys, err := e.processEnvVars(input)
+	if err != nil {
+		return nil, err
+	}
+	config.EnvVars = envVars
+	config.DeletedKeys = deletedKeys
+
+	return config, nil
+}
+
+// printableInput summarizes the variables input, as the values declared with the sensitive: marker are not in
+// the secret env vars list and so they would not be redacted from the build log.
+func printableInput(input Input) Input {
+	if input.EnvVars != "" {
+		input.EnvVars = fmt.Sprintf("[REDACTED] (%d lines)", strings.Count(input.EnvVars, "\n")+1)
+	}
+	return input
+}
+
+// checkAPIInputs checks the inputs needed to call the API, which are not needed to validate the variables.
+func checkAPIInputs(input Input) error {
+	var missing []string
+	if input.AppURL == "" {
+		missing = append(missing, "app_url")
+	}
+	if input.BuildSlug == "" {
+		missing = append(missing, "build_slug")
+	}
+	if input.BuildAPIToken == "" {
+		missing = append(missing, "build_api_token")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s should be set to call the API", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (e EnvVarSharer) processEnvVars(input Input) ([]EnvVar, []string, error) {
 	secretKeys := e.secretKeysProvider.Load(e.envRepository)
 
 	if len(secretKeys) == 0 {
 		e.logger.Printf("Secret keys list is empty.")
 	}
 
-	envVars, err := e.parseEnvVars(input.EnvVars, secretKeys)
+	declarations, err := parseInputDeclarations(input)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	keyValidator, err := NewKeyValidator(input.ReservedKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	declarations, deletions, err := splitDeletions(declarations, keyValidator)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envVars, err := e.resolveEnvVars(declarations, parseOptions{
+		secretKeys:            secretKeys,
+		missingVariablePolicy: input.MissingVariablePolicy,
+		keyValidator:          keyValidator,
+		secretDetection:       input.SecretDetection,
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return &Config{
-		EnvVars:       envVars,
-		AppURL:        input.AppURL,
-		BuildSlug:     input.BuildSlug,
-		BuildAPIToken: input.BuildAPIToken,
-	}, nil
+	envVars, err = e.resolveDuplicates(envVars, input.DuplicatePolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deletedKeys, err := checkDeletions(envVars, deletions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := e.checkLimits(envVars, Limits{
+		MaxKeyLength:       input.MaxKeyLength,
+		MaxValueLength:     input.MaxValueLength,
+		MaxVariableCount:   input.MaxVariableCount,
+		MaxRequestBodySize: input.MaxRequestBodySize,
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return envVars, deletedKeys, nil
 }
 
-func (e EnvVarSharer) Run(config Config) error {
-	e.logger.Infof("Sharing %d env vars", len(config.EnvVars))
+// Run calls the API with the config, the API calls are cancelled when ctx is done or the timeout of the config
+// is exceeded.
+func (e EnvVarSharer) Run(ctx context.Context, config Config) error {
+	if config.Mode == ModeValidate {
+		return e.reportDiagnostics(config.Diagnostics)
+	}
+
+	if config.DryRun && config.Mode != ModeList {
+		if err := e.preview(config); err != nil {
+			return err
+		}
+		return e.exportReport(config, config.EnvVars, 0, nil)
+	}
+
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	if config.Mode == ModeList {
+		return apiCallError(e.listSharedEnvVars(ctx, config), config.Timeout)
+	}
+
+	envVars, statusCode, err := e.share(ctx, config)
+	err = apiCallError(err, config.Timeout)
+	if reportErr := e.exportReport(config, envVars, statusCode, err); reportErr != nil {
+		if err != nil {
+			e.logger.Warnf("Failed to export the share report: %s", reportErr)
+			return err
+		}
+		return reportErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if config.ExportToWorkflow {
+		return e.exportEnvVars(envVars)
+	}
+
+	return nil
+}
 
-	client := api.NewBitriseClient(config.AppURL, config.BuildSlug, config.BuildAPIToken, e.logger)
-	if err := client.ShareEnvVars(config.APIEnvVars()); err != nil {
+func apiCallError(err error, timeout time.Duration) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("API calls did not finish in %s: %w", timeout, err)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("API calls were cancelled, the build was aborted: %w", err)
+	default:
 		return err
 	}
+}
+
+// share deletes and shares the env vars of the config, and returns the env vars it shared or tried to share.
+func (e EnvVarSharer) share(ctx context.Context, config Config) ([]EnvVar, int, error) {
+	client := api.NewBitriseClientWithOptions(config.AppURL, config.BuildSlug, config.BuildAPIToken, config.clientOptions(), e.logger)
+
+	if len(config.DeletedKeys) > 0 {
+		e.logger.Infof("Deleting %d shared env vars: %s", len(config.DeletedKeys), strings.Join(config.DeletedKeys, ", "))
+
+		if err := client.DeleteSharedEnvVars(ctx, config.DeletedKeys); err != nil {
+			return config.EnvVars, 0, err
+		}
+
+		if len(config.EnvVars) == 0 {
+			e.logger.Donef("Finished")
+			return nil, 0, nil
+		}
+	}
+
+	if config.ConflictPolicy != "" && config.ConflictPolicy != ConflictPolicyOverwrite {
+		sharedEnvVars, err := client.ListSharedEnvVars(ctx)
+		if err != nil {
+			return config.EnvVars, 0, fmt.Errorf("failed to list the shared env vars: %w", err)
+		}
+
+		envVars, err := e.resolveConflicts(config.EnvVars, sharedEnvVars, config.ConflictPolicy)
+		if err != nil {
+			return config.EnvVars, 0, err
+		}
+		config.EnvVars = envVars
+		if len(config.EnvVars) == 0 {
+			e.logger.Donef("Every env var is already shared, nothing to share")
+			return nil, 0, nil
+		}
+	}
+
+	e.logger.Infof("Sharing %d env vars", len(config.EnvVars))
+
+	statusCode, err := client.ShareEnvVars(ctx, config.APIEnvVars())
+	if err != nil {
+		return config.EnvVars, statusCode, err
+	}
 
 	e.logger.Donef("Finished")
 
+	return config.EnvVars, statusCode, nil
+}
+
+func (e EnvVarSharer) listSharedEnvVars(ctx context.Context, config Config) error {
+	client := api.NewBitriseClientWithOptions(config.AppURL, config.BuildSlug, config.BuildAPIToken, config.clientOptions(), e.logger)
+	sharedEnvVars, err := client.ListSharedEnvVars(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.logger.Infof("%d env vars are shared by the Pipeline", len(sharedEnvVars))
+	for _, envVar := range sharedEnvVars {
+		if envVar.Sensitive {
+			e.logger.Printf("- %s (sensitive): [REDACTED]", envVar.Key)
+		} else {
+			e.logger.Printf("- %s: %s", envVar.Key, strconv.Quote(envVar.Value))
+		}
+	}
+
+	return nil
+}
+
+const maxPreviewLength = 40
+
+// preview prints what would be shared without calling the API.
+func (e EnvVarSharer) preview(config Config) error {
+	e.logger.Infof("Dry run: %d env vars would be shared", len(config.EnvVars))
+
+	for _, envVar := range config.EnvVars {
+		if envVar.Sensitive {
+			e.logger.Printf("- %s (sensitive, %s): [REDACTED] (%d bytes)", envVar.Key, envVar.Source, len(envVar.Value))
+		} else {
+			e.logger.Printf("- %s (%s): %s", envVar.Key, envVar.Source, previewValue(envVar.Value))
+		}
+	}
+
+	if len(config.DeletedKeys) > 0 {
+		e.logger.Printf("%d shared env vars would be deleted: %s", len(config.DeletedKeys), strings.Join(config.DeletedKeys, ", "))
+	}
+
+	bodySize, err := api.RequestBodySize(config.APIEnvVars())
+	if err != nil {
+		return err
+	}
+	batches, err := api.SplitIntoBatches(config.APIEnvVars(), config.MaxRequestBodySize, config.MaxBatchSize)
+	if err != nil {
+		return err
+	}
+	e.logger.Printf("Request body size: %d bytes, shared in %d request(s)", bodySize, len(batches))
+
+	e.logger.Donef("Dry run finished, nothing was shared")
+
 	return nil
 }
 
-func (e EnvVarSharer) parseEnvVars(input string, secretKeys []string) ([]EnvVar, error) {
+func previewValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= maxPreviewLength {
+		return strconv.Quote(value)
+	}
+	return fmt.Sprintf("%s... (%d bytes)", strconv.Quote(string(runes[:maxPreviewLength])), len(value))
+}
+
+type parseOptions struct {
+	secretKeys            []string
+	missingVariablePolicy MissingVariablePolicy
+	keyValidator          KeyValidator
+	secretDetection       SecretDetection
+}
+
+// envVarRef identifies an env var resolved from a declaration, a declaration resolves to a key at most once.
+type envVarRef struct {
+	key    string
+	source string
+}
+
+// parseInputDeclarations parses the variables file and then the variables input.
+func parseInputDeclarations(input Input) ([]declaration, error) {
+	if input.EnvVars == "" && input.EnvVarsFile == "" {
+		return nil, errors.New("either variables or variables_file should be set")
+	}
+
+	var declarations []declaration
+	if input.EnvVarsFile != "" {
+		content, err := os.ReadFile(input.EnvVarsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variables file: %w", err)
+		}
+
+		fileDeclarations, err := parseDeclarationsWithFormat(string(content), input.EnvVarsFile, variablesFileFormat(input.EnvVarsFile))
+		if err != nil {
+			return nil, err
+		}
+		declarations = append(declarations, fileDeclarations...)
+	}
+
+	inputDeclarations, err := parseDeclarationsWithFormat(input.EnvVars, "", input.EnvVarsFormat)
+	if err != nil {
+		return nil, err
+	}
+	return append(declarations, inputDeclarations...), nil
+}
+
+func (e EnvVarSharer) resolveEnvVars(declarations []declaration, options parseOptions) ([]EnvVar, error) {
+	environment := e.environment()
+
 	var envVars []EnvVar
+	var invalidKeys []string
+	var missingKeys []string
+	declaredNonSensitive := map[envVarRef]bool{}
+	matchedByPattern := map[envVarRef]bool{}
+	for _, declaration := range declarations {
+		if declaration.exclude {
+			envVars = e.excludeEnvVars(envVars, declaration)
+			continue
+		}
 
-	lines := strings.Split(input, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			// empty line is ignored
+		if declaration.pattern {
+			expandedEnvVars := e.expandPattern(declaration, environment, options.secretKeys)
+			for _, envVar := range expandedEnvVars {
+				matchedByPattern[envVarRef{key: envVar.Key, source: envVar.Source}] = true
+				if declaration.sensitive != nil && !*declaration.sensitive {
+					declaredNonSensitive[envVarRef{key: envVar.Key, source: envVar.Source}] = true
+				}
+			}
+			envVars = append(envVars, expandedEnvVars...)
 			continue
 		}
 
-		key, value, _ := strings.Cut(line, "=")
-		if key == "" {
-			// line starting with = is invalid
-			return nil, fmt.Errorf("env var should be in a format: KEY=value or KEY: %s", line)
+		if err := options.keyValidator.Validate(declaration.key); err != nil {
+			invalidKeys = append(invalidKeys, fmt.Sprintf("%s: %s %s", declaration.source, declaration.key, err))
+			continue
 		}
-		if value == "" {
-			value = e.envRepository.Get(key)
+
+		value := declaration.value
+		if declaration.lookup {
+			var isSet bool
+			value, isSet = environment[declaration.key]
+			if !isSet {
+				switch options.missingVariablePolicy {
+				case MissingVariablePolicyWarnAndSkip:
+					e.logger.Warnf("%s (%s) is not set, skipping it", declaration.key, declaration.source)
+					continue
+				case MissingVariablePolicyShareEmpty:
+					e.logger.Warnf("%s (%s) is not set, sharing it with an empty value", declaration.key, declaration.source)
+				default:
+					missingKeys = append(missingKeys, fmt.Sprintf("%s (%s)", declaration.key, declaration.source))
+					continue
+				}
+			}
 		}
 
-		isSensitive := slices.Contains(secretKeys, key)
+		if declaration.sensitive != nil && !*declaration.sensitive {
+			declaredNonSensitive[envVarRef{key: declaration.key, source: declaration.source}] = true
+		}
+
+		isSensitive := declaration.isSensitive(declaration.key, options.secretKeys)
 		envVars = append(envVars, EnvVar{
-			Key:       key,
+			Key:       declaration.key,
 			Value:     value,
 			Sensitive: isSensitive,
+			Source:    declaration.source,
 		})
 	}
 
+	// env vars matched by a pattern are validated once the exclusions are applied, the ones which can't be shared
+	// are skipped, as a broad pattern like BITRISE_* is expected to match some of them
+	var skippedKeys []string
+	var validEnvVars []EnvVar
+	for _, envVar := range envVars {
+		if matchedByPattern[envVarRef{key: envVar.Key, source: envVar.Source}] {
+			if err := options.keyValidator.Validate(envVar.Key); err != nil {
+				skippedKeys = append(skippedKeys, fmt.Sprintf("%s: %s %s", envVar.Source, envVar.Key, err))
+				continue
+			}
+		}
+		validEnvVars = append(validEnvVars, envVar)
+	}
+	envVars = validEnvVars
+
+	if len(skippedKeys) > 0 {
+		e.logger.Warnf("Skipping env vars matched by a pattern which can't be shared:\n- %s", strings.Join(skippedKeys, "\n- "))
+	}
+
+	if len(invalidKeys) > 0 {
+		return nil, fmt.Errorf("invalid env var keys:\n- %s", strings.Join(invalidKeys, "\n- "))
+	}
+	if len(missingKeys) > 0 {
+		return nil, fmt.Errorf("env vars to share are not set: %s", strings.Join(missingKeys, ", "))
+	}
+
+	return e.detectSecrets(envVars, declaredNonSensitive, options.secretDetection)
+}
+
+// detectSecrets looks for credentials in the values of the env vars which would be shared as non-sensitive,
+// except the ones explicitly declared non-sensitive. Findings are reported by key, values are never printed.
+func (e EnvVarSharer) detectSecrets(envVars []EnvVar, declaredNonSensitive map[envVarRef]bool, detection SecretDetection) ([]EnvVar, error) {
+	if detection == "" || detection == SecretDetectionOff {
+		return envVars, nil
+	}
+
+	var findings []string
+	for i, envVar := range envVars {
+		if envVar.Sensitive || declaredNonSensitive[envVarRef{key: envVar.Key, source: envVar.Source}] {
+			continue
+		}
+
+		kind := detectSecret(envVar.Value)
+		if kind == "" {
+			continue
+		}
+
+		if detection == SecretDetectionMarkSensitive {
+			e.logger.Warnf("%s (%s): possible %s, sharing it as sensitive", envVar.Key, envVar.Source, kind)
+			envVars[i].Sensitive = true
+		} else {
+			findings = append(findings, fmt.Sprintf("%s (%s): possible %s", envVar.Key, envVar.Source, kind))
+		}
+	}
+
+	if len(findings) > 0 {
+		return nil, fmt.Errorf("possible secrets found in non-sensitive env vars, mark them with sensitive: or nonsensitive:\n- %s", strings.Join(findings, "\n- "))
+	}
+
 	return envVars, nil
 }
+
+func (e EnvVarSharer) environment() map[string]string {
+	environment := map[string]string{}
+	for _, env := range e.envRepository.List() {
+		key, value, _ := strings.Cut(env, "=")
+		environment[key] = value
+	}
+	return environment
+}
+
+func (e EnvVarSharer) expandPattern(pattern declaration, environment map[string]string, secretKeys []string) []EnvVar {
+	keys := matchingKeys(pattern.key, environment)
+
+	if len(keys) == 0 {
+		e.logger.Warnf("%s did not match any env vars", pattern.key)
+	} else {
+		e.logger.Printf("%s matched %d env vars: %s", pattern.key, len(keys), strings.Join(keys, ", "))
+	}
+
+	var envVars []EnvVar
+	for _, key := range keys {
+		isSensitive := pattern.isSensitive(key, secretKeys)
+		envVars = append(envVars, EnvVar{
+			Key:       key,
+			Value:     environment[key],
+			Sensitive: isSensitive,
+			Source:    pattern.source,
+		})
+	}
+
+	return envVars
+}
+
+// matchingKeys returns the sorted keys of the environment matching the pattern.
+func matchingKeys(pattern string, environment map[string]string) []string {
+	var keys []string
+	for key := range environment {
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (e EnvVarSharer) excludeEnvVars(envVars []EnvVar, exclusion declaration) []EnvVar {
+	var kept []EnvVar
+	var excludedKeys []string
+	for _, envVar := range envVars {
+		excluded := envVar.Key == exclusion.key
+		if exclusion.pattern {
+			excluded, _ = path.Match(exclusion.key, envVar.Key)
+		}
+
+		if excluded {
+			excludedKeys = append(excludedKeys, envVar.Key)
+		} else {
+			kept = append(kept, envVar)
+		}
+	}
+
+	if len(excludedKeys) == 0 {
+		e.logger.Warnf("!%s did not exclude any env vars", exclusion.key)
+	} else {
+		e.logger.Printf("!%s excluded %d env vars: %s", exclusion.key, len(excludedKeys), strings.Join(excludedKeys, ", "))
+	}
+
+	return kept
+}
+
+// splitDeletions separates the -KEY deletions from the declarations of the env vars to share.
+func splitDeletions(declarations []declaration, keyValidator KeyValidator) ([]declaration, []declaration, error) {
+	var kept, deletions []declaration
+	var invalidKeys []string
+	for _, declaration := range declarations {
+		if !declaration.remove {
+			kept = append(kept, declaration)
+			continue
+		}
+
+		if err := keyValidator.Validate(declaration.key); err != nil {
+			invalidKeys = append(invalidKeys, fmt.Sprintf("%s: %s %s", declaration.source, declaration.key, err))
+			continue
+		}
+		deletions = append(deletions, declaration)
+	}
+
+	if len(invalidKeys) > 0 {
+		return nil, nil, fmt.Errorf("invalid env var keys to delete:\n- %s", strings.Join(invalidKeys, "\n- "))
+	}
+
+	return kept, deletions, nil
+}
+
+// checkDeletions returns the keys to delete, a key can't be deleted and shared by the same step.
+func checkDeletions(envVars []EnvVar, deletions []declaration) ([]string, error) {
+	sharedSources := map[string]string{}
+	for _, envVar := range envVars {
+		sharedSources[envVar.Key] = envVar.Source
+	}
+
+	var keys []string
+	var conflicts []string
+	for _, deletion := range deletions {
+		if source, shared := sharedSources[deletion.key]; shared {
+			conflicts = append(conflicts, fmt.Sprintf("%s (shared at %s, deleted at %s)", deletion.key, source, deletion.source))
+			continue
+		}
+		if !slices.Contains(keys, deletion.key) {
+			keys = append(keys, deletion.key)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("env vars can't be shared and deleted at the same time:\n- %s", strings.Join(conflicts, "\n- "))
+	}
+
+	return keys, nil
+}
+
+func (e EnvVarSharer) resolveDuplicates(envVars []EnvVar, policy DuplicatePolicy) ([]EnvVar, error) {
+	sourcesByKey := map[string][]string{}
+	var duplicateKeys []string
+	for _, envVar := range envVars {
+		sourcesByKey[envVar.Key] = append(sourcesByKey[envVar.Key], envVar.Source)
+		if len(sourcesByKey[envVar.Key]) == 2 {
+			duplicateKeys = append(duplicateKeys, envVar.Key)
+		}
+	}
+	if len(duplicateKeys) == 0 {
+		return envVars, nil
+	}
+
+	if policy == DuplicatePolicyError {
+		var duplicates []string
+		for _, key := range duplicateKeys {
+			duplicates = append(duplicates, fmt.Sprintf("%s (%s)", key, strings.Join(sourcesByKey[key], ", ")))
+		}
+		return nil, fmt.Errorf("env vars are declared multiple times:\n- %s", strings.Join(duplicates, "\n- "))
+	}
+
+	winners := map[string]int{}
+	for i, envVar := range envVars {
+		if _, found := winners[envVar.Key]; !found || policy == DuplicatePolicyLastWins {
+			winners[envVar.Key] = i
+		}
+	}
+
+	for _, key := range duplicateKeys {
+		e.logger.Warnf("%s is declared multiple times (%s), using the definition from %s", key, strings.Join(sourcesByKey[key], ", "), envVars[winners[key]].Source)
+	}
+
+	var resolved []EnvVar
+	for i, envVar := range envVars {
+		if winners[envVar.Key] == i {
+			resolved = append(resolved, envVar)
+		}
+	}
+
+	return resolved, nil
+}
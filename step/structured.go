@@ -0,0 +1,200 @@
+package step
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type VariablesFormat string
+
+const (
+	VariablesFormatAuto  VariablesFormat = "auto"
+	VariablesFormatLines VariablesFormat = "lines"
+	VariablesFormatJSON  VariablesFormat = "json"
+	VariablesFormatYAML  VariablesFormat = "yaml"
+)
+
+// detectVariablesFormat recognises JSON documents and YAML lists, other inputs are handled as a list of lines.
+// YAML maps can't be told apart from the lines format, so they need an explicit format.
+func detectVariablesFormat(input string) VariablesFormat {
+	for _, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, commentPrefix):
+			continue
+		case strings.HasPrefix(line, "[") || strings.HasPrefix(line, "{"):
+			return VariablesFormatJSON
+		case line == "---" || line == "-" || strings.HasPrefix(line, "- "):
+			return VariablesFormatYAML
+		default:
+			return VariablesFormatLines
+		}
+	}
+	return VariablesFormatLines
+}
+
+// variablesFileFormat selects the format of a variables file by its extension, other files are handled as dotenv files.
+func variablesFileFormat(pth string) VariablesFormat {
+	switch strings.ToLower(filepath.Ext(pth)) {
+	case ".json":
+		return VariablesFormatJSON
+	case ".yml", ".yaml":
+		return VariablesFormatYAML
+	default:
+		return VariablesFormatLines
+	}
+}
+
+func parseDeclarationsWithFormat(input, origin string, format VariablesFormat) ([]declaration, error) {
+	if format == "" || format == VariablesFormatAuto {
+		format = detectVariablesFormat(input)
+	}
+
+	switch format {
+	case VariablesFormatJSON, VariablesFormatYAML:
+		return parseStructuredDeclarations(input, origin)
+	default:
+		return parseDeclarations(input, origin)
+	}
+}
+
+// parseStructuredDeclarations parses a JSON or YAML document, which is either a list of
+// {key, value, sensitive} entries or a map of keys to values. JSON is parsed as YAML to get the line numbers
+// of the entries.
+func parseStructuredDeclarations(input, origin string) ([]declaration, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &document); err != nil {
+		return nil, fmt.Errorf("invalid variables document: %w", err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	var declarations []declaration
+	var entryErrors []string
+	root := document.Content[0]
+	switch root.Kind {
+	case yaml.SequenceNode:
+		for i, entry := range root.Content {
+			d, err := parseListEntry(entry, origin)
+			if err != nil {
+				entryErrors = append(entryErrors, fmt.Sprintf("entry %d (%s): %s", i+1, formatSource(origin, entry.Line), err))
+				continue
+			}
+			declarations = append(declarations, d)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			keyNode, valueNode := root.Content[i], root.Content[i+1]
+			d, err := parseMapEntry(keyNode, valueNode, origin)
+			if err != nil {
+				entryErrors = append(entryErrors, fmt.Sprintf("entry %s (%s): %s", keyNode.Value, formatSource(origin, keyNode.Line), err))
+				continue
+			}
+			declarations = append(declarations, d)
+		}
+	default:
+		return nil, errors.New("invalid variables document: should be a list of {key, value, sensitive} entries or a map of keys to values")
+	}
+
+	if len(entryErrors) > 0 {
+		return nil, fmt.Errorf("invalid variables document:\n- %s", strings.Join(entryErrors, "\n- "))
+	}
+
+	return declarations, nil
+}
+
+func parseListEntry(entry *yaml.Node, origin string) (declaration, error) {
+	if entry.Kind != yaml.MappingNode {
+		return declaration{}, errors.New("should be a {key, value, sensitive} object")
+	}
+
+	d := declaration{
+		source: formatSource(origin, entry.Line),
+		lookup: true,
+	}
+	for i := 0; i+1 < len(entry.Content); i += 2 {
+		fieldNode, valueNode := entry.Content[i], entry.Content[i+1]
+		switch fieldNode.Value {
+		case "key":
+			if valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!str" {
+				return declaration{}, errors.New("key should be a string")
+			}
+			d.key = valueNode.Value
+		case "value":
+			if isNull(valueNode) {
+				continue
+			}
+			if valueNode.Kind != yaml.ScalarNode {
+				return declaration{}, errors.New("value should be a string")
+			}
+			d.value = valueNode.Value
+			d.lookup = false
+		case "sensitive":
+			if isNull(valueNode) {
+				continue
+			}
+			var sensitive bool
+			if valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!bool" || valueNode.Decode(&sensitive) != nil {
+				return declaration{}, errors.New("sensitive should be a boolean")
+			}
+			d.sensitive = &sensitive
+		default:
+			return declaration{}, fmt.Errorf("unknown field: %s", fieldNode.Value)
+		}
+	}
+
+	if d.key == "" {
+		return declaration{}, errors.New("key is required")
+	}
+	if isPattern(d.key) {
+		if !d.lookup {
+			return declaration{}, errors.New("a pattern can't have a value")
+		}
+		if _, err := path.Match(d.key, ""); err != nil {
+			return declaration{}, fmt.Errorf("invalid pattern %s: %w", d.key, err)
+		}
+		d.pattern = true
+	}
+
+	return d, nil
+}
+
+func parseMapEntry(keyNode, valueNode *yaml.Node, origin string) (declaration, error) {
+	if keyNode.Value == "" {
+		return declaration{}, errors.New("key is required")
+	}
+
+	d := declaration{
+		source: formatSource(origin, keyNode.Line),
+		key:    keyNode.Value,
+	}
+	switch {
+	case isNull(valueNode):
+		if isPattern(d.key) {
+			if _, err := path.Match(d.key, ""); err != nil {
+				return declaration{}, fmt.Errorf("invalid pattern %s: %w", d.key, err)
+			}
+			d.pattern = true
+		}
+		d.lookup = true
+	case valueNode.Kind == yaml.ScalarNode:
+		if isPattern(d.key) {
+			return declaration{}, errors.New("a pattern can't have a value")
+		}
+		d.value = valueNode.Value
+	default:
+		return declaration{}, errors.New("value should be a string")
+	}
+
+	return d, nil
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
@@ -0,0 +1,142 @@
+package step
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeclarationsWithFormat(t *testing.T) {
+	sensitive := true
+	notSensitive := false
+
+	tests := []struct {
+		name    string
+		input   string
+		format  VariablesFormat
+		want    []declaration
+		wantErr string
+	}{
+		{
+			name:   "Auto detected lines",
+			input:  "BUILD_TYPE=debug",
+			format: VariablesFormatAuto,
+			want:   []declaration{{source: "line 1", key: "BUILD_TYPE", value: "debug"}},
+		},
+		{
+			name: "Auto detected JSON list",
+			input: `[
+  {"key": "BUILD_TYPE", "value": "debug"},
+  {"key": "SIGNED_URL", "value": "https://example.com", "sensitive": true},
+  {"key": "EXISTING", "sensitive": false},
+  {"key": "APP_*"}
+]`,
+			format: VariablesFormatAuto,
+			want: []declaration{
+				{source: "line 2", key: "BUILD_TYPE", value: "debug"},
+				{source: "line 3", key: "SIGNED_URL", value: "https://example.com", sensitive: &sensitive},
+				{source: "line 4", key: "EXISTING", lookup: true, sensitive: &notSensitive},
+				{source: "line 5", key: "APP_*", lookup: true, pattern: true},
+			},
+		},
+		{
+			name:   "Auto detected JSON map",
+			input:  `{"BUILD_TYPE": "debug", "BUILD_NUMBER": 42, "EMPTY": "", "EXISTING": null}`,
+			format: VariablesFormatAuto,
+			want: []declaration{
+				{source: "line 1", key: "BUILD_TYPE", value: "debug"},
+				{source: "line 1", key: "BUILD_NUMBER", value: "42"},
+				{source: "line 1", key: "EMPTY", value: ""},
+				{source: "line 1", key: "EXISTING", lookup: true},
+			},
+		},
+		{
+			name: "Auto detected YAML list",
+			input: `# generated
+- key: BUILD_TYPE
+  value: debug
+- key: NOTES
+  value: |-
+    line1
+    line2
+  sensitive: false`,
+			format: VariablesFormatAuto,
+			want: []declaration{
+				{source: "line 2", key: "BUILD_TYPE", value: "debug"},
+				{source: "line 4", key: "NOTES", value: "line1\nline2", sensitive: &notSensitive},
+			},
+		},
+		{
+			name:   "YAML map",
+			input:  "BUILD_TYPE: debug\nEXISTING:",
+			format: VariablesFormatYAML,
+			want: []declaration{
+				{source: "line 1", key: "BUILD_TYPE", value: "debug"},
+				{source: "line 2", key: "EXISTING", lookup: true},
+			},
+		},
+		{
+			name:   "Explicit lines format",
+			input:  "- KEY=value",
+			format: VariablesFormatLines,
+			want:   []declaration{{source: "line 1", key: "- KEY", value: "value"}},
+		},
+		{
+			name: "Schema errors are reported per entry",
+			input: `[
+  {"value": "debug"},
+  {"key": "BUILD_TYPE", "value": ["debug"]},
+  {"key": "SECRET", "sensitive": "yes"},
+  {"key": "OTHER", "unknown": 1},
+  {"key": "APP_*", "value": "value"},
+  "KEY=value",
+  {"key": "VALID", "value": "value"}
+]`,
+			format: VariablesFormatJSON,
+			wantErr: `invalid variables document:
+- entry 1 (line 2): key is required
+- entry 2 (line 3): value should be a string
+- entry 3 (line 4): sensitive should be a boolean
+- entry 4 (line 5): unknown field: unknown
+- entry 5 (line 6): a pattern can't have a value
+- entry 6 (line 7): should be a {key, value, sensitive} object`,
+		},
+		{
+			name:    "Map schema errors",
+			input:   "BUILD_TYPE:\n  nested: value",
+			format:  VariablesFormatYAML,
+			wantErr: "invalid variables document:\n- entry BUILD_TYPE (line 1): value should be a string",
+		},
+		{
+			name:    "Not a list or a map",
+			input:   `"KEY=value"`,
+			format:  VariablesFormatJSON,
+			wantErr: "invalid variables document: should be a list of {key, value, sensitive} entries or a map of keys to values",
+		},
+		{
+			name:    "Invalid JSON",
+			input:   `[{"key": "BUILD_TYPE"`,
+			format:  VariablesFormatAuto,
+			wantErr: "invalid variables document: yaml: line 1: did not find expected ',' or '}'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDeclarationsWithFormat(tt.input, "", tt.format)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVariablesFileFormat(t *testing.T) {
+	require.Equal(t, VariablesFormatJSON, variablesFileFormat("vars.json"))
+	require.Equal(t, VariablesFormatYAML, variablesFileFormat("vars.yml"))
+	require.Equal(t, VariablesFormatYAML, variablesFileFormat("vars.YAML"))
+	require.Equal(t, VariablesFormatLines, variablesFileFormat("build.env"))
+	require.Equal(t, VariablesFormatLines, variablesFileFormat(".env"))
+}
@@ -0,0 +1,70 @@
+package step
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DefaultReservedKeys are env vars which are managed by the build environment and must not be overridden
+// in the next stage's workflows.
+var DefaultReservedKeys = []string{
+	"PATH",
+	"HOME",
+	"USER",
+	"SHELL",
+	"PWD",
+	"TMPDIR",
+	"CI",
+	"ENVMAN_ENVSTORE_PATH",
+	"BITRISE_APP_SLUG",
+	"BITRISE_APP_URL",
+	"BITRISE_BUILD_API_TOKEN",
+	"BITRISE_BUILD_NUMBER",
+	"BITRISE_BUILD_SLUG",
+	"BITRISE_BUILD_URL",
+	"BITRISE_DEPLOY_DIR",
+	"BITRISE_SECRET_ENV_KEY_LIST",
+	"BITRISE_SOURCE_DIR",
+	"BITRISE_TRIGGERED_WORKFLOW_ID",
+	"BITRISE_TRIGGERED_WORKFLOW_TITLE",
+}
+
+var keyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type KeyValidator struct {
+	reservedKeys []string
+}
+
+// NewKeyValidator creates a validator which rejects the DefaultReservedKeys and the given additional reserved
+// keys. Reserved keys can be glob patterns.
+func NewKeyValidator(additionalReservedKeys []string) (KeyValidator, error) {
+	reservedKeys := append([]string{}, DefaultReservedKeys...)
+	for _, key := range additionalReservedKeys {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if _, err := path.Match(key, ""); err != nil {
+			return KeyValidator{}, fmt.Errorf("invalid reserved key pattern %s: %w", key, err)
+		}
+		reservedKeys = append(reservedKeys, key)
+	}
+
+	return KeyValidator{reservedKeys: reservedKeys}, nil
+}
+
+func (v KeyValidator) Validate(key string) error {
+	if !keyRegexp.MatchString(key) {
+		return errors.New("should start with a letter or underscore and contain only letters, digits and underscores")
+	}
+
+	for _, reservedKey := range v.reservedKeys {
+		if matched, _ := path.Match(reservedKey, key); matched {
+			return errors.New("is reserved by the build environment and can't be shared")
+		}
+	}
+
+	return nil
+}